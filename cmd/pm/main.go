@@ -2,19 +2,28 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"pm/internal/config"
 	"pm/internal/packager"
+	"pm/internal/signing"
 	"pm/internal/sshcmd"
 	"pm/internal/updater"
+	"pm/internal/workspace"
 )
 
 func main() {
@@ -36,6 +45,22 @@ func main() {
 		err = runCreate(args)
 	case "update":
 		err = runUpdate(args)
+	case "remove":
+		err = runRemove(args)
+	case "list":
+		err = runList(args)
+	case "inspect":
+		err = runInspect(args)
+	case "validate":
+		err = runValidate(args)
+	case "schema":
+		err = runSchema(args)
+	case "spec":
+		err = runSpec(args)
+	case "fmt":
+		err = runFmt(args)
+	case "keys":
+		err = runKeys(args)
 	case "help", "-h", "--help":
 		usage()
 		return
@@ -51,17 +76,51 @@ func main() {
 
 func usage() {
 	fmt.Println(`Usage:
-  pm create <spec> [flags]
+  pm create <spec|workspace> [flags]
   pm update <spec> [flags]
+  pm remove <package> [--local-dir dir] [--no-scripts]
+  pm list [--local-dir dir] [--tag tag] [--long]
+  pm inspect <archive|name[@constraint]> [--json] [flags]
+  pm validate <spec>... [--json]
+  pm schema <package|update|workspace>
+  pm schema --output dir
+  pm spec render <spec> [--format json|yaml|toml]
+  pm fmt <spec>... [--write] [--check] [--to json|yaml|toml]
+  pm keys generate <name> [--keys-dir dir]
+  pm keys list [--keys-dir dir]
+  pm keys trust <public-key> [--name name] [--keys-dir dir]
+
+Flags may go before or after the arguments; "--" ends the flags.
 
 Flags:
   --ssh-host       SSH host (can use PM_SSH_HOST)
   --ssh-port       SSH port (default 22 or PM_SSH_PORT)
   --ssh-user       SSH user (PM_SSH_USER)
   --ssh-key        Path to private key (PM_SSH_KEY)
-  --remote-dir     Remote directory for archives (PM_REMOTE_DIR)
-  --output         Output archive path (create command)
-  --local-dir      Destination directory (update command, default current)`)
+  --remote-dir     Remote directory for archives (PM_REMOTE_DIR); inspect looks up packages given by name there
+  --output         Output archive path, or output directory for a workspace (create command); directory for the schema files (schema command)
+  --format         Archive format: tar.gz (default), tar.zst, tar or zip (create command, overrides spec "format"); output format json (default), yaml or toml (spec render command)
+  --compression    gzip[:level], zstd[:level], deflate[:level] (zip) or none (create command, overrides spec "compression")
+  --follow-symlinks  Package what symlinks point to instead of the links (create command)
+  --local-dir      Destination directory (update, remove and list commands, default current)
+  --keys-dir       Directory with signing and trusted keys (PM_KEYS_DIR)
+  --sign-key       Key name or private key file to sign archives (create command, PM_SIGN_KEY)
+  --allow-unsigned Install packages without a valid signature (update command)
+  --max-size       Maximum uncompressed size of one archive, e.g. 512M (update command, default 4G, PM_MAX_EXTRACT_SIZE)
+  --max-entries    Maximum number of entries in one archive (update command, default 100000, PM_MAX_EXTRACT_ENTRIES)
+  --with-optional  Also install optional dependencies (update command)
+  --no-scripts     Do not run package install/remove scripts (update and remove commands)
+  --reproducible   Build a byte-identical archive (create command, on when SOURCE_DATE_EPOCH is set)
+  --source-date-epoch  Timestamp used by --reproducible (SOURCE_DATE_EPOCH, default 0)
+  --dry-run        List selected and excluded files with the rules responsible, write nothing (create command)
+  --write          Rewrite the spec files instead of printing them, or with --to write <spec>.<format> next to them (fmt command)
+  --check          List spec files that are not formatted and fail if there are any (fmt command)
+  --to             Convert specs to json, yaml or toml (fmt command)
+  --json           Print the --dry-run report (create command), the inspect report or the validate problems as JSON
+  --platform       Target os/arch such as linux/arm64: overrides spec "os"/"arch" (create command) or replaces the host platform when choosing packages (update and inspect commands, PM_PLATFORM)
+  --jobs           Packages built in parallel for a workspace spec (create command, default number of CPUs)
+  --tag            Only list installed packages with this tag (list command)
+  --long           Show metadata and relations of installed packages (list command)`)
 }
 
 func runCreate(args []string) error {
@@ -74,48 +133,330 @@ func runCreate(args []string) error {
 	sshKey := fs.String("ssh-key", getenv("PM_SSH_KEY", defaultSSHKeyPath()), "SSH private key")
 	remoteDir := fs.String("remote-dir", getenv("PM_REMOTE_DIR", ""), "Remote directory")
 	outputPath := fs.String("output", "", "Output archive path")
+	format := fs.String("format", "", "Archive format (tar.gz, tar.zst, tar, zip)")
+	compression := fs.String("compression", "", "Compression codec and level (gzip[:1-9], zstd[:1-22], deflate[:1-9], none)")
+	followSymlinks := fs.Bool("follow-symlinks", false, "Package link targets instead of the links")
+	keysDir := fs.String("keys-dir", signing.DefaultKeysDir(), "Keys directory")
+	signKey := fs.String("sign-key", getenv("PM_SIGN_KEY", ""), "Signing key name or path")
+	reproducible := fs.Bool("reproducible", getenv("SOURCE_DATE_EPOCH", "") != "", "Build a byte-identical archive")
+	sourceDate := fs.Int64("source-date-epoch", getenvInt64("SOURCE_DATE_EPOCH", 0), "Timestamp for reproducible builds")
+	dryRun := fs.Bool("dry-run", false, "Show what would be packaged without writing an archive")
+	asJSON := fs.Bool("json", false, "Print the dry-run report as JSON")
+	jobs := fs.Int("jobs", runtime.NumCPU(), "Packages built in parallel in a workspace")
+	platform := fs.String("platform", "", "Target os/arch, overrides spec os and arch")
 
-	if err := fs.Parse(args); err != nil {
+	if err := parseArgs(fs, args); err != nil {
 		return err
 	}
+	if *asJSON && !*dryRun {
+		return fmt.Errorf("--json requires --dry-run")
+	}
 
 	if fs.NArg() < 1 {
 		return fmt.Errorf("missing package spec path")
 	}
+	if err := checkArgs(fs, 1); err != nil {
+		return err
+	}
 	specPath := fs.Arg(0)
 
+	run := &createRun{
+		opts: packager.CreateOptions{
+			OutputPath:     *outputPath,
+			Format:         *format,
+			Compression:    *compression,
+			Reproducible:   *reproducible,
+			SourceDate:     time.Unix(*sourceDate, 0),
+			FollowSymlinks: *followSymlinks,
+			Platform:       *platform,
+			Warnf: func(format string, args ...any) {
+				log.Printf("warning: "+format, args...)
+			},
+		},
+		dryRun: *dryRun,
+		asJSON: *asJSON,
+		ssh: sshcmd.Config{
+			Host:     *sshHost,
+			Port:     *sshPort,
+			User:     *sshUser,
+			Identity: *sshKey,
+		},
+		remoteDir: *remoteDir,
+	}
+	if *signKey != "" && !*dryRun {
+		key, err := signing.ResolvePrivateKey(*keysDir, *signKey)
+		if err != nil {
+			return err
+		}
+		run.signer = key
+	}
+
+	isWorkspace, err := config.IsWorkspace(specPath)
+	if err != nil {
+		return err
+	}
+	if isWorkspace {
+		return run.workspace(specPath, *jobs)
+	}
+	return run.single(specPath)
+}
+
+type createRun struct {
+	opts      packager.CreateOptions
+	dryRun    bool
+	asJSON    bool
+	signer    *signing.PrivateKey
+	ssh       sshcmd.Config
+	remoteDir string
+}
+
+func (r *createRun) single(specPath string) error {
 	spec, err := config.LoadPackageSpec(specPath)
 	if err != nil {
 		return err
 	}
 
-	archivePath, manifest, err := packager.Create(spec, packager.CreateOptions{OutputPath: *outputPath})
+	if r.dryRun {
+		plan, err := packager.DryRun(spec, r.opts)
+		if err != nil {
+			return err
+		}
+		if r.asJSON {
+			if err := printJSON(plan); err != nil {
+				return err
+			}
+		} else {
+			printPlan(plan)
+		}
+		if len(plan.Errors) > 0 {
+			return fmt.Errorf("%d target requirements not met", len(plan.Errors))
+		}
+		return nil
+	}
+
+	archivePath, manifest, err := packager.Create(spec, r.opts)
 	if err != nil {
 		return err
 	}
 
 	fmt.Printf("Created archive %s containing %d files\n", archivePath, len(manifest.Files))
 
-	if *sshHost == "" {
+	sigPath, err := r.sign(archivePath)
+	if err != nil {
+		return err
+	}
+	if sigPath != "" {
+		fmt.Printf("Signed with key %s (%s): %s\n", r.signer.Name, r.signer.ID, sigPath)
+	}
+
+	if r.ssh.Host == "" {
 		fmt.Println("SSH host not provided, skipping upload")
 		return nil
 	}
 
-	cfg := sshcmd.Config{
-		Host:     *sshHost,
-		Port:     *sshPort,
-		User:     *sshUser,
-		Identity: *sshKey,
+	remotePaths, err := r.upload([]string{archivePath})
+	if err != nil {
+		return err
 	}
-	remotePath, err := sshcmd.UploadFile(cfg, archivePath, *remoteDir)
+
+	fmt.Printf("Uploaded to %s\n", remotePaths[0])
+	return nil
+}
+
+// sign writes the detached signature of an archive. Without a signing key
+// it removes the signature of an earlier build instead, which would not
+// match the new archive.
+func (r *createRun) sign(archivePath string) (string, error) {
+	if r.signer == nil {
+		if err := os.Remove(archivePath + signing.SignatureExt); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		return "", nil
+	}
+	return signing.SignFile(archivePath, r.signer)
+}
+
+// upload copies archives and, when signing, their signatures over one ssh
+// session, and returns the remote paths of the archives. Signatures go
+// first so an archive never appears on the remote without its signature;
+// for unsigned archives a signature left on the remote by an earlier
+// upload is removed first.
+func (r *createRun) upload(archives []string) ([]string, error) {
+	var files, stale []string
+	for _, archivePath := range archives {
+		if r.signer != nil {
+			files = append(files, archivePath+signing.SignatureExt)
+		} else {
+			stale = append(stale, path.Join(r.remoteDir, filepath.Base(archivePath))+signing.SignatureExt)
+		}
+	}
+	files = append(files, archives...)
+	remotePaths, err := sshcmd.UploadFiles(r.ssh, files, r.remoteDir, stale)
+	if err != nil {
+		return nil, err
+	}
+	return remotePaths[len(files)-len(archives):], nil
+}
+
+func (r *createRun) workspace(specPath string, jobs int) error {
+	ws, err := config.LoadWorkspace(specPath)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Uploaded to %s\n", remotePath)
+	// In a workspace --output names the directory for all archives.
+	opts := r.opts
+	opts.OutputDir, opts.OutputPath = opts.OutputPath, ""
+
+	if r.dryRun {
+		return r.workspaceDryRun(ws, opts)
+	}
+
+	results, err := workspace.Build(ws, jobs, func(m *config.WorkspaceMember) (string, int, error) {
+		memberOpts := opts
+		memberOpts.WorkingDir = m.Dir
+		memberOpts.Warnf = func(format string, args ...any) {
+			log.Printf("warning: %s: %s", m.Spec.Name, fmt.Sprintf(format, args...))
+		}
+		archivePath, manifest, err := packager.Create(m.Spec, memberOpts)
+		if err != nil {
+			return "", 0, err
+		}
+		if _, err := r.sign(archivePath); err != nil {
+			return "", 0, err
+		}
+		return archivePath, len(manifest.Files), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var built []string
+	failed := 0
+	for _, res := range results {
+		if res.Status == workspace.StatusOK {
+			built = append(built, res.Archive)
+		} else {
+			failed++
+		}
+	}
+
+	var uploadErr error
+	switch {
+	case len(built) == 0:
+	case r.ssh.Host == "":
+		fmt.Println("SSH host not provided, skipping upload")
+	default:
+		if _, uploadErr = r.upload(built); uploadErr == nil {
+			fmt.Printf("Uploaded %d archives to %s:%s\n", len(built), r.ssh.Host, r.remoteDir)
+		}
+	}
+
+	fmt.Printf("Workspace %s: %d built, %d not built\n", ws.Path, len(built), failed)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, res := range results {
+		detail := fmt.Sprintf("%s (%d files)", res.Archive, res.Files)
+		if res.Err != nil {
+			detail = res.Err.Error()
+		}
+		fmt.Fprintf(w, "  %s\t%s %s\t%s\n", res.Status, res.Member.Spec.Name, res.Member.Spec.Version, detail)
+	}
+	w.Flush()
+
+	if uploadErr != nil {
+		return uploadErr
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d packages were not built", failed, len(results))
+	}
+	return nil
+}
+
+func (r *createRun) workspaceDryRun(ws *config.Workspace, opts packager.CreateOptions) error {
+	type memberPlan struct {
+		Spec string         `json:"spec"`
+		Plan *packager.Plan `json:"plan"`
+	}
+	var plans []memberPlan
+	problems := 0
+	for _, m := range ws.Members {
+		memberOpts := opts
+		memberOpts.WorkingDir = m.Dir
+		plan, err := packager.DryRun(m.Spec, memberOpts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", m.SpecPath, err)
+		}
+		problems += len(plan.Errors)
+		plans = append(plans, memberPlan{Spec: m.SpecPath, Plan: plan})
+	}
+
+	if r.asJSON {
+		if err := printJSON(plans); err != nil {
+			return err
+		}
+	} else {
+		for i, p := range plans {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("== %s\n", p.Spec)
+			printPlan(p.Plan)
+		}
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d target requirements not met", problems)
+	}
 	return nil
 }
 
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printPlan(plan *packager.Plan) {
+	fmt.Printf("Would create %s (%s, %s, %s) with %d files, %d bytes\n", plan.Output, plan.Format, plan.Compression, plan.Platform, len(plan.Files), plan.TotalSize())
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, f := range plan.Files {
+		name := f.Path
+		if f.Source != "" {
+			name += " <- " + f.Source
+		}
+		if f.Type != packager.EntryFile {
+			name += " (" + f.Type + ")"
+		}
+		fmt.Fprintf(w, "  %s\t%d\t%s\n", name, f.Size, f.Pattern)
+	}
+	w.Flush()
+
+	if len(plan.Excluded) > 0 {
+		fmt.Println("Excluded:")
+		for _, e := range plan.Excluded {
+			name := e.Path
+			if e.Dir {
+				name += "/"
+			}
+			fmt.Fprintf(w, "  %s\t%s\n", name, e.Rule)
+		}
+		w.Flush()
+	}
+
+	if len(plan.Unmatched) > 0 {
+		fmt.Println("Targets that matched nothing:")
+		for _, t := range plan.Unmatched {
+			fmt.Printf("  %s\n", t)
+		}
+	}
+
+	if len(plan.Errors) > 0 {
+		fmt.Println("Errors:")
+		for _, e := range plan.Errors {
+			fmt.Printf("  %s\n", e)
+		}
+	}
+}
+
 func runUpdate(args []string) error {
 	fs := flag.NewFlagSet("update", flag.ContinueOnError)
 	fs.SetOutput(os.Stdout)
@@ -126,14 +467,37 @@ func runUpdate(args []string) error {
 	sshKey := fs.String("ssh-key", getenv("PM_SSH_KEY", defaultSSHKeyPath()), "SSH private key")
 	remoteDir := fs.String("remote-dir", getenv("PM_REMOTE_DIR", ""), "Remote directory")
 	localDir := fs.String("local-dir", ".", "Local extraction directory")
+	keysDir := fs.String("keys-dir", signing.DefaultKeysDir(), "Keys directory")
+	allowUnsigned := fs.Bool("allow-unsigned", false, "Allow packages without a trusted signature")
+	maxSize := fs.String("max-size", getenv("PM_MAX_EXTRACT_SIZE", "4G"), "Maximum uncompressed size per archive (0 = unlimited)")
+	maxEntries := fs.Int("max-entries", getenvInt("PM_MAX_EXTRACT_ENTRIES", 100000), "Maximum number of entries per archive (0 = unlimited)")
+	noScripts := fs.Bool("no-scripts", false, "Do not run package scripts")
+	withOptional := fs.Bool("with-optional", false, "Also install optional dependencies")
+	platformName := fs.String("platform", getenv("PM_PLATFORM", ""), "Install packages for this os/arch instead of the host's")
 
-	if err := fs.Parse(args); err != nil {
+	if err := parseArgs(fs, args); err != nil {
 		return err
 	}
 
+	platform := config.HostPlatform()
+	if *platformName != "" {
+		var err error
+		if platform, err = config.ParsePlatform(*platformName); err != nil {
+			return err
+		}
+	}
+
+	maxBytes, err := parseSize(*maxSize)
+	if err != nil {
+		return fmt.Errorf("invalid --max-size: %w", err)
+	}
+
 	if fs.NArg() < 1 {
 		return fmt.Errorf("missing update spec path")
 	}
+	if err := checkArgs(fs, 1); err != nil {
+		return err
+	}
 	specPath := fs.Arg(0)
 
 	if *sshHost == "" {
@@ -144,6 +508,11 @@ func runUpdate(args []string) error {
 		return err
 	}
 
+	trusted, err := signing.LoadTrustedKeys(*keysDir)
+	if err != nil {
+		return err
+	}
+
 	cfg := sshcmd.Config{
 		Host:     *sshHost,
 		Port:     *sshPort,
@@ -152,9 +521,18 @@ func runUpdate(args []string) error {
 	}
 
 	results, err := updater.Update(spec, updater.UpdateOptions{
-		RemoteDir: *remoteDir,
-		LocalDir:  *localDir,
-		SSH:       cfg,
+		RemoteDir:     *remoteDir,
+		LocalDir:      *localDir,
+		SSH:           cfg,
+		TrustedKeys:   trusted,
+		AllowUnsigned: *allowUnsigned,
+		Limits: updater.ExtractLimits{
+			MaxTotalSize: maxBytes,
+			MaxEntries:   *maxEntries,
+		},
+		NoScripts:    *noScripts,
+		WithOptional: *withOptional,
+		Platform:     platform,
 	})
 	if err != nil {
 		return err
@@ -165,7 +543,530 @@ func runUpdate(args []string) error {
 		if res.Manifest != "" {
 			manifestInfo = fmt.Sprintf(", manifest %s", res.Manifest)
 		}
-		fmt.Printf("Downloaded %s %s to %s (archive %s%s)\n", res.PackageName, res.Version, res.ExtractedTo, res.ArchivePath, manifestInfo)
+		if res.SignedBy != "" {
+			manifestInfo += fmt.Sprintf(", signed by %s", res.SignedBy)
+		}
+		name := res.PackageName + " " + res.Version
+		if !res.Platform.IsAny() {
+			name += " " + res.Platform.String()
+		}
+		fmt.Printf("Downloaded %s to %s (archive %s%s)\n", name, res.ExtractedTo, res.ArchivePath, manifestInfo)
+		for _, replaced := range res.Replaced {
+			fmt.Printf("  replaced %s\n", replaced)
+		}
+	}
+	return nil
+}
+
+func runRemove(args []string) error {
+	fs := flag.NewFlagSet("remove", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+
+	localDir := fs.String("local-dir", ".", "Installation directory")
+	noScripts := fs.Bool("no-scripts", false, "Do not run package scripts")
+
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return fmt.Errorf("missing package name")
+	}
+	if err := checkArgs(fs, 1); err != nil {
+		return err
+	}
+
+	res, err := updater.Remove(fs.Arg(0), updater.RemoveOptions{
+		LocalDir:  *localDir,
+		NoScripts: *noScripts,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed %s %s from %s (%d files)\n", res.PackageName, res.Version, *localDir, res.Removed)
+	return nil
+}
+
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+
+	localDir := fs.String("local-dir", ".", "Installation directory")
+	tag := fs.String("tag", "", "Only list packages with this tag")
+	long := fs.Bool("long", false, "Show all package metadata")
+
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+
+	manifests, err := updater.Installed(*localDir)
+	if err != nil {
+		return err
+	}
+	if *tag != "" {
+		var tagged []*packager.Manifest
+		for _, m := range manifests {
+			for _, t := range m.Tags {
+				if strings.EqualFold(t, *tag) {
+					tagged = append(tagged, m)
+					break
+				}
+			}
+		}
+		manifests = tagged
+	}
+
+	if *long {
+		for i, m := range manifests {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s %s\n", m.Name, m.Version)
+			printMetadata(m, *localDir)
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tLICENSE\tDESCRIPTION")
+	for _, m := range manifests {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.Name, m.Version, m.License, m.Description)
+	}
+	return w.Flush()
+}
+
+func runInspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+
+	sshHost := fs.String("ssh-host", getenv("PM_SSH_HOST", ""), "SSH host")
+	sshPort := fs.Int("ssh-port", getenvInt("PM_SSH_PORT", 22), "SSH port")
+	sshUser := fs.String("ssh-user", getenv("PM_SSH_USER", ""), "SSH user")
+	sshKey := fs.String("ssh-key", getenv("PM_SSH_KEY", defaultSSHKeyPath()), "SSH private key")
+	remoteDir := fs.String("remote-dir", getenv("PM_REMOTE_DIR", ""), "Remote directory")
+	keysDir := fs.String("keys-dir", signing.DefaultKeysDir(), "Keys directory")
+	platformName := fs.String("platform", getenv("PM_PLATFORM", ""), "Pick the build for this os/arch instead of the host's")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return fmt.Errorf("missing archive path or package name")
+	}
+	if err := checkArgs(fs, 1); err != nil {
+		return err
+	}
+
+	var platform config.Platform
+	if *platformName != "" {
+		var err error
+		if platform, err = config.ParsePlatform(*platformName); err != nil {
+			return err
+		}
+	}
+	trusted, err := signing.LoadTrustedKeys(*keysDir)
+	if err != nil {
+		return err
+	}
+
+	insp, err := updater.Inspect(fs.Arg(0), updater.InspectOptions{
+		RemoteDir: *remoteDir,
+		SSH: sshcmd.Config{
+			Host:     *sshHost,
+			Port:     *sshPort,
+			User:     *sshUser,
+			Identity: *sshKey,
+		},
+		TrustedKeys: trusted,
+		Platform:    platform,
+	})
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(insp)
+	}
+	printInspection(insp)
+	return nil
+}
+
+func printInspection(insp *updater.Inspection) {
+	m := insp.Manifest
+	platform := config.Platform{OS: m.OS, Arch: m.Arch}
+	fmt.Printf("%s %s (%s)\n", m.Name, m.Version, platform)
+
+	signature := insp.Signature.Status
+	switch insp.Signature.Status {
+	case updater.SignatureValid:
+		signature += fmt.Sprintf(", signed by %s (%s)", insp.Signature.Key, insp.Signature.KeyID)
+	case updater.SignatureUntrusted, updater.SignatureInvalid:
+		signature += ": " + insp.Signature.Error
+	}
+	where := insp.Archive
+	if insp.Remote {
+		where += " (remote)"
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "  Archive:\t%s\n", where)
+	fmt.Fprintf(w, "  Format:\t%s, %s, %d bytes\n", insp.Format, m.Compression, insp.Size)
+	fmt.Fprintf(w, "  SHA-256:\t%s\n", insp.SHA256)
+	fmt.Fprintf(w, "  Signature:\t%s\n", signature)
+	fmt.Fprintf(w, "  Created:\t%s\n", m.CreatedAt.Format(time.RFC3339))
+	w.Flush()
+	printMetadata(m, "")
+	if len(m.Scripts) > 0 {
+		hooks := make([]string, 0, len(m.Scripts))
+		for hook := range m.Scripts {
+			hooks = append(hooks, hook)
+		}
+		sort.Strings(hooks)
+		fmt.Printf("  Scripts: %s\n", strings.Join(hooks, ", "))
+	}
+
+	var total int64
+	for _, f := range m.Files {
+		total += f.Size
+	}
+	fmt.Printf("Files (%d, %d bytes):\n", len(m.Files), total)
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, f := range m.Files {
+		name := f.Path
+		switch f.Type {
+		case packager.EntryDir:
+			name += "/"
+		case packager.EntrySymlink, packager.EntryHardlink:
+			name += " -> " + f.Link
+		}
+		fmt.Fprintf(w, "  %s\t%d\t%04o\n", name, f.Size, f.Mode)
+	}
+	w.Flush()
+}
+
+// printMetadata prints the descriptive fields and relations of a manifest
+// that are set.
+// The README path is shown relative to dir.
+func printMetadata(m *packager.Manifest, dir string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	for _, field := range []struct{ name, value string }{
+		{"Description:", m.Description},
+		{"License:", m.License},
+		{"Maintainers:", strings.Join(m.Maintainers, ", ")},
+		{"Homepage:", m.Homepage},
+		{"Tags:", strings.Join(m.Tags, ", ")},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(w, "  %s\t%s\n", field.name, field.value)
+		}
+	}
+	if m.Readme != "" {
+		fmt.Fprintf(w, "  README:\t%s\n", filepath.Join(dir, filepath.FromSlash(m.Readme)))
+	}
+	for _, rel := range []struct {
+		name string
+		deps []config.DependencySpec
+	}{
+		{"Depends:", m.Dependencies},
+		{"Provides:", m.Provides},
+		{"Conflicts:", m.Conflicts},
+		{"Replaces:", m.Replaces},
+	} {
+		if len(rel.deps) == 0 {
+			continue
+		}
+		names := make([]string, len(rel.deps))
+		for i, dep := range rel.deps {
+			names[i] = dep.String()
+		}
+		fmt.Fprintf(w, "  %s\t%s\n", rel.name, strings.Join(names, ", "))
+	}
+	w.Flush()
+}
+
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	asJSON := fs.Bool("json", false, "Print the problems as JSON")
+
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return fmt.Errorf("missing spec path")
+	}
+	if err := checkArgs(fs, -1); err != nil {
+		return err
+	}
+
+	opts := config.ValidateOptions{
+		CheckVersion: func(s string) error {
+			_, err := updater.ParseVersion(s)
+			return err
+		},
+		CheckConstraint: func(s string) error {
+			_, err := updater.ParseConstraint(s)
+			return err
+		},
+	}
+	var results []*config.Validation
+	problems := 0
+	for _, path := range fs.Args() {
+		res, err := config.Validate(path, opts)
+		if err != nil {
+			return err
+		}
+		results = append(results, res)
+		problems += len(res.Problems)
+	}
+
+	if *asJSON {
+		if err := printJSON(results); err != nil {
+			return err
+		}
+	} else {
+		for _, res := range results {
+			if len(res.Problems) == 0 {
+				fmt.Printf("%s: ok (%s spec)\n", res.Spec, res.Kind)
+			}
+			for _, p := range res.Problems {
+				fmt.Println(p)
+			}
+		}
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d problem(s) found", problems)
+	}
+	return nil
+}
+
+func runSchema(args []string) error {
+	fs := flag.NewFlagSet("schema", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	outputDir := fs.String("output", "", "Write <kind>.schema.json for every spec kind to this directory")
+
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if *outputDir == "" {
+		if fs.NArg() < 1 {
+			return fmt.Errorf("name a spec kind (%s, %s or %s) or use --output", config.SpecPackage, config.SpecUpdate, config.SpecWorkspace)
+		}
+		if err := checkArgs(fs, 1); err != nil {
+			return err
+		}
+		data, err := config.JSONSchema(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Printf("%s\n", data)
+		return err
+	}
+
+	if err := checkArgs(fs, 0); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*outputDir, 0o755); err != nil {
+		return err
+	}
+	for _, kind := range []string{config.SpecPackage, config.SpecUpdate, config.SpecWorkspace} {
+		data, err := config.JSONSchema(kind)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(*outputDir, kind+".schema.json")
+		if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+			return err
+		}
+		fmt.Println("Wrote", path)
+	}
+	return nil
+}
+
+func runSpec(args []string) error {
+	if len(args) < 1 || args[0] != "render" {
+		return fmt.Errorf("usage: pm spec render <spec> [--format json|yaml|toml]")
+	}
+	fs := flag.NewFlagSet("spec render", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	format := fs.String("format", "json", "Output format (json, yaml or toml)")
+
+	if err := parseArgs(fs, args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return fmt.Errorf("missing spec path")
+	}
+	if err := checkArgs(fs, 1); err != nil {
+		return err
+	}
+	data, err := config.RenderSpec(fs.Arg(0), *format)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+func runFmt(args []string) error {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	write := fs.Bool("write", false, "Rewrite the spec files, or write converted copies with --to")
+	check := fs.Bool("check", false, "List spec files that are not formatted and fail if there are any")
+	to := fs.String("to", "", "Convert to json, yaml or toml")
+
+	if err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return fmt.Errorf("missing spec path")
+	}
+	if err := checkArgs(fs, -1); err != nil {
+		return err
+	}
+	if *check && (*write || *to != "") {
+		return fmt.Errorf("--check cannot be combined with --write or --to")
+	}
+
+	unformatted := 0
+	for _, path := range fs.Args() {
+		data, format, err := config.FormatSpec(path, *to)
+		if err != nil {
+			return err
+		}
+		if !*check && !*write {
+			if _, err := os.Stdout.Write(data); err != nil {
+				return err
+			}
+			continue
+		}
+
+		out := path
+		if *to != "" && *to != format {
+			out = strings.TrimSuffix(path, filepath.Ext(path)) + "." + *to
+			if _, err := os.Stat(out); err == nil {
+				return fmt.Errorf("%s already exists", out)
+			}
+		} else {
+			old, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(old, data) {
+				continue
+			}
+		}
+		if *check {
+			fmt.Println(path)
+			unformatted++
+			continue
+		}
+		if err := os.WriteFile(out, data, 0o644); err != nil {
+			return err
+		}
+		fmt.Println("Wrote", out)
+	}
+	if unformatted > 0 {
+		return fmt.Errorf("%d spec(s) not formatted", unformatted)
+	}
+	return nil
+}
+
+func runKeys(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("missing keys subcommand (generate, list, trust)")
+	}
+	sub := args[0]
+
+	fs := flag.NewFlagSet("keys "+sub, flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	keysDir := fs.String("keys-dir", signing.DefaultKeysDir(), "Keys directory")
+	name := fs.String("name", "", "Name for the trusted key (trust subcommand)")
+
+	if err := parseArgs(fs, args[1:]); err != nil {
+		return err
+	}
+
+	switch sub {
+	case "generate":
+		if fs.NArg() < 1 {
+			return fmt.Errorf("missing key name")
+		}
+		if err := checkArgs(fs, 1); err != nil {
+			return err
+		}
+		key, err := signing.Generate(*keysDir, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Generated key %s (%s) in %s\n", key.Name, key.ID, *keysDir)
+	case "list":
+		if err := checkArgs(fs, 0); err != nil {
+			return err
+		}
+		own, err := signing.ListKeys(*keysDir)
+		if err != nil {
+			return err
+		}
+		trusted, err := signing.LoadTrustedKeys(*keysDir)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Signing keys:")
+		for _, key := range own {
+			fmt.Printf("  %s  %s\n", key.ID, key.Name)
+		}
+		fmt.Println("Trusted keys:")
+		for _, key := range trusted {
+			fmt.Printf("  %s  %s\n", key.ID, key.Name)
+		}
+	case "trust":
+		if fs.NArg() < 1 {
+			return fmt.Errorf("missing public key path")
+		}
+		if err := checkArgs(fs, 1); err != nil {
+			return err
+		}
+		key, err := signing.Trust(*keysDir, fs.Arg(0), *name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Trusted key %s (%s)\n", key.Name, key.ID)
+	default:
+		return fmt.Errorf("unknown keys subcommand %q", sub)
+	}
+	return nil
+}
+
+// parseArgs parses flags wherever they appear, so that both
+// "pm create spec.json --dry-run" and "pm create --dry-run spec.json" work;
+// the flag package alone stops at the first argument. Everything after
+// "--" is an argument.
+func parseArgs(fs *flag.FlagSet, args []string) error {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		rest := fs.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+		if len(rest) == 0 {
+			break
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+	return fs.Parse(append([]string{"--"}, positional...))
+}
+
+// checkArgs rejects arguments beyond the max a command takes, which would
+// otherwise be ignored. max < 0 allows any number of arguments.
+func checkArgs(fs *flag.FlagSet, max int) error {
+	if max >= 0 && fs.NArg() > max {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(max))
 	}
 	return nil
 }
@@ -186,6 +1087,32 @@ func getenvInt(key string, def int) int {
 	return def
 }
 
+func getenvInt64(key string, def int64) int64 {
+	if val := os.Getenv(key); val != "" {
+		if v, err := strconv.ParseInt(val, 10, 64); err == nil {
+			return v
+		}
+	}
+	return def
+}
+
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	multiplier := int64(1)
+	for suffix, m := range map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40} {
+		if strings.HasSuffix(s, suffix) {
+			multiplier = m
+			s = strings.TrimSuffix(s, suffix)
+			break
+		}
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("expected a size like 512M or 4G, got %q", s)
+	}
+	return v * multiplier, nil
+}
+
 func loadDotEnv(path string) error {
 	f, err := os.Open(path)
 	if err != nil {
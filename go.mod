@@ -1,3 +1,10 @@
 module pm
 
 go 1.25.3
+
+require (
+	buf.build/go/spdx v0.2.0
+	github.com/klauspost/compress v1.20.1
+	github.com/pelletier/go-toml/v2 v2.3.1
+	gopkg.in/yaml.v3 v3.0.1
+)
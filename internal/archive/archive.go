@@ -0,0 +1,185 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+)
+
+type EntryType int
+
+const (
+	TypeFile EntryType = iota
+	TypeDir
+	TypeSymlink
+	TypeHardlink
+)
+
+type Header struct {
+	Name     string
+	Type     EntryType
+	Linkname string
+	Mode     int64
+	Size     int64
+	ModTime  time.Time
+	Uid      int
+	Gid      int
+	Uname    string
+	Gname    string
+}
+
+type Writer interface {
+	WriteHeader(h *Header) error
+	io.Writer
+	Close() error
+}
+
+type Reader interface {
+	Next() (*Header, error)
+	io.Reader
+	Close() error
+}
+
+type Format struct {
+	Name      string
+	Extension string
+	Codec     string
+	Codecs    []string
+	Hardlinks bool
+	// Streaming formats can be read front to back with NewTarReader.
+	Streaming bool
+	NewWriter func(w io.Writer, c Compression) (Writer, error)
+	Open      func(path string) (Reader, error)
+}
+
+func (f *Format) supports(codec string) bool {
+	for _, c := range f.Codecs {
+		if c == codec {
+			return true
+		}
+	}
+	return false
+}
+
+const DefaultFormat = "tar.gz"
+
+var tarFormatByCodec = map[string]string{
+	CodecGzip: "tar.gz",
+	CodecZstd: "tar.zst",
+	CodecNone: "tar",
+}
+
+// Resolve combines a format name and a compression setting. For the tar
+// family the codec picks the variant, so "tar" with "zstd" becomes tar.zst,
+// and "deflate" alone picks zip.
+func Resolve(formatName, compression string) (*Format, Compression, error) {
+	comp, err := ParseCompression(compression)
+	if err != nil {
+		return nil, Compression{}, err
+	}
+	if comp.Codec == CodecDeflate && formatName == "" {
+		formatName = "zip"
+	}
+	if comp.Codec != "" && (formatName == "" || strings.HasPrefix(formatName, "tar")) {
+		variant, ok := tarFormatByCodec[comp.Codec]
+		if !ok || formatName != "" && formatName != "tar" && formatName != variant {
+			return nil, Compression{}, fmt.Errorf("format %s cannot use compression %s", formatName, comp.Codec)
+		}
+		formatName = variant
+	}
+	f, err := Lookup(formatName)
+	if err != nil {
+		return nil, Compression{}, err
+	}
+	if comp.Codec == "" {
+		comp.Codec = f.Codec
+	}
+	if f.Name == "zip" && comp.Codec == CodecGzip {
+		// zip deflates its entries; gzip is what this codec was called
+		// before it got its own name.
+		comp.Codec = CodecDeflate
+	}
+	if !f.supports(comp.Codec) {
+		return nil, Compression{}, fmt.Errorf("format %s cannot use compression %s", f.Name, comp.Codec)
+	}
+	return f, comp, nil
+}
+
+var formats = map[string]*Format{}
+
+func register(f *Format) {
+	formats[f.Name] = f
+}
+
+func Lookup(name string) (*Format, error) {
+	if name == "" {
+		name = DefaultFormat
+	}
+	f, ok := formats[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported archive format %q (supported: %s)", name, strings.Join(Names(), ", "))
+	}
+	return f, nil
+}
+
+func Names() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ForFile picks the format by the longest matching extension and returns the
+// filename without it.
+func ForFile(filename string) (*Format, string, bool) {
+	var best *Format
+	for _, f := range formats {
+		if strings.HasSuffix(filename, f.Extension) && (best == nil || len(f.Extension) > len(best.Extension)) {
+			best = f
+		}
+	}
+	if best == nil {
+		return nil, "", false
+	}
+	return best, strings.TrimSuffix(filename, best.Extension), true
+}
+
+func Open(path string) (Reader, error) {
+	f, _, ok := ForFile(path)
+	if !ok {
+		return nil, fmt.Errorf("unsupported archive %s", path)
+	}
+	return f.Open(path)
+}
+
+func FileInfoHeader(info fs.FileInfo, link string) (*Header, error) {
+	th, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return nil, err
+	}
+	h := &Header{
+		Name:    info.Name(),
+		Mode:    int64(info.Mode().Perm()),
+		ModTime: info.ModTime(),
+		Uid:     th.Uid,
+		Gid:     th.Gid,
+		Uname:   th.Uname,
+		Gname:   th.Gname,
+	}
+	switch {
+	case info.IsDir():
+		h.Type = TypeDir
+	case info.Mode()&fs.ModeSymlink != 0:
+		h.Type = TypeSymlink
+		h.Linkname = link
+	default:
+		h.Size = info.Size()
+	}
+	return h, nil
+}
@@ -0,0 +1,158 @@
+package archive
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testEntry struct {
+	Header
+	data string
+}
+
+// roundTrip writes entries in a format and reads them back, with their
+// contents, through the format's reader.
+func roundTrip(t *testing.T, format string, compression string, entries []testEntry) []testEntry {
+	t.Helper()
+	f, c, err := Resolve(format, compression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "test"+f.Extension)
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	w, err := f.NewWriter(out, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		h := e.Header
+		if err := w.WriteHeader(&h); err != nil {
+			t.Fatal(err)
+		}
+		if e.data == "" {
+			continue
+		}
+		if _, err := io.WriteString(w, e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var got []testEntry
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, testEntry{Header: *h, data: string(data)})
+	}
+	return got
+}
+
+func TestRoundTrip(t *testing.T) {
+	mtime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	file := func(name string, mode int64, data string) testEntry {
+		return testEntry{Header: Header{Name: name, Type: TypeFile, Mode: mode, Size: int64(len(data)), ModTime: mtime}, data: data}
+	}
+	dir := func(name string, mode int64) testEntry {
+		return testEntry{Header: Header{Name: name, Type: TypeDir, Mode: mode, ModTime: mtime}}
+	}
+	entries := []testEntry{
+		file("manifest.json", 0o644, `{"name":"app"}`),
+		dir("bin", 0o755),
+		file("bin/app", 0o755, "#!/bin/sh\necho app\n"),
+		{Header: Header{Name: "bin/alias", Type: TypeSymlink, Linkname: "app", Mode: 0o777, ModTime: mtime}},
+		dir("share/empty/", 0o700),
+		file("etc/app.conf", 0o600, ""),
+		file("share/doc/big.txt", 0o644, strings.Repeat("line of text\n", 10000)),
+	}
+	// Directories come back with a trailing slash.
+	want := make([]testEntry, len(entries))
+	copy(want, entries)
+	want[1].Name = "bin/"
+
+	tests := []struct {
+		format, compression string
+	}{
+		{"tar.gz", ""},
+		{"tar.gz", "gzip:9"},
+		{"tar.zst", ""},
+		{"tar", ""},
+		{"zip", ""},
+		{"zip", "deflate:1"},
+		{"zip", "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format+"+"+tt.compression, func(t *testing.T) {
+			got := roundTrip(t, tt.format, tt.compression, entries)
+			if len(got) != len(want) {
+				t.Fatalf("got %d entries, want %d: %+v", len(got), len(want), got)
+			}
+			for i := range want {
+				g, w := got[i], want[i]
+				if g.Name != w.Name || g.Type != w.Type || g.Mode != w.Mode || g.Linkname != w.Linkname || g.Size != w.Size || g.data != w.data {
+					t.Errorf("entry %d: got %s %v %o %q size %d (%d bytes), want %s %v %o %q size %d (%d bytes)",
+						i, g.Name, g.Type, g.Mode, g.Linkname, g.Size, len(g.data), w.Name, w.Type, w.Mode, w.Linkname, w.Size, len(w.data))
+				}
+				if !g.ModTime.Equal(w.ModTime) {
+					t.Errorf("%s: got mtime %v, want %v", g.Name, g.ModTime, w.ModTime)
+				}
+			}
+		})
+	}
+}
+
+// Hard links are kept by tar and refused by zip, which has no entry type
+// for them.
+func TestRoundTripHardlinks(t *testing.T) {
+	entries := []testEntry{
+		{Header: Header{Name: "a", Type: TypeFile, Mode: 0o644, Size: 4}, data: "data"},
+		{Header: Header{Name: "b", Type: TypeHardlink, Linkname: "a", Mode: 0o644}},
+	}
+	got := roundTrip(t, "tar", "", entries)
+	var names []string
+	for _, e := range got {
+		names = append(names, e.Name+" "+e.Linkname)
+	}
+	if want := []string{"a ", "b a"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %q, want %q", names, want)
+	}
+
+	f, err := Lookup("zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Hardlinks {
+		t.Error("zip claims to support hard links")
+	}
+	w, err := f.NewWriter(io.Discard, Compression{Codec: CodecDeflate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteHeader(&entries[1].Header); err == nil || !strings.Contains(err.Error(), "zip: unsupported entry type for b") {
+		t.Errorf("got %v, want an unsupported entry type error", err)
+	}
+}
@@ -0,0 +1,128 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CodecGzip    = "gzip"
+	CodecZstd    = "zstd"
+	CodecDeflate = "deflate"
+	CodecNone    = "none"
+)
+
+type Compression struct {
+	Codec string
+	Level int
+}
+
+func ParseCompression(s string) (Compression, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Compression{}, nil
+	}
+	codec, levelStr, hasLevel := strings.Cut(s, ":")
+	c := Compression{Codec: strings.ToLower(strings.TrimSpace(codec))}
+	if hasLevel {
+		level, err := strconv.Atoi(strings.TrimSpace(levelStr))
+		if err != nil {
+			return Compression{}, fmt.Errorf("invalid compression level in %q", s)
+		}
+		c.Level = level
+	}
+
+	switch c.Codec {
+	case CodecGzip, CodecDeflate:
+		if c.Level != 0 && (c.Level < gzip.BestSpeed || c.Level > gzip.BestCompression) {
+			return Compression{}, fmt.Errorf("%s level must be between %d and %d, or 0 for the default", c.Codec, gzip.BestSpeed, gzip.BestCompression)
+		}
+	case CodecZstd:
+		if c.Level < 0 || c.Level > 22 {
+			return Compression{}, fmt.Errorf("zstd level must be between 1 and 22, or 0 for the default")
+		}
+	case CodecNone:
+		if hasLevel {
+			return Compression{}, fmt.Errorf("compression none does not take a level")
+		}
+	default:
+		return Compression{}, fmt.Errorf("unsupported compression %q (supported: gzip, zstd, deflate, none)", codec)
+	}
+	return c, nil
+}
+
+func (c Compression) String() string {
+	if c.Level == 0 {
+		return c.Codec
+	}
+	return fmt.Sprintf("%s:%d", c.Codec, c.Level)
+}
+
+func compressWriter(w io.Writer, c Compression) (io.WriteCloser, error) {
+	switch c.Codec {
+	case CodecGzip:
+		level := c.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case CodecZstd:
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if c.Level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.Level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	case CodecNone:
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", c.Codec)
+	}
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompressReader detects the codec from the stream's magic bytes, so an
+// archive is readable even if its extension does not match its contents.
+func decompressReader(r io.Reader) (io.Reader, io.Closer, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gz, gz, nil
+	case bytes.HasPrefix(head, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, closerFunc(func() error { zr.Close(); return nil }), nil
+	default:
+		return br, nil, nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
@@ -0,0 +1,177 @@
+package archive
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		format, compression string
+		want                string
+		codec               Compression
+		err                 string
+	}{
+		{format: "", compression: "", want: "tar.gz", codec: Compression{Codec: CodecGzip}},
+		{format: "tar.gz", compression: "gzip:9", want: "tar.gz", codec: Compression{Codec: CodecGzip, Level: 9}},
+		{format: "tar", compression: "", want: "tar", codec: Compression{Codec: CodecNone}},
+		{format: "tar", compression: "zstd", want: "tar.zst", codec: Compression{Codec: CodecZstd}},
+		{format: "tar", compression: "gzip:1", want: "tar.gz", codec: Compression{Codec: CodecGzip, Level: 1}},
+		{format: "", compression: "zstd:19", want: "tar.zst", codec: Compression{Codec: CodecZstd, Level: 19}},
+		{format: "", compression: "none", want: "tar", codec: Compression{Codec: CodecNone}},
+		{format: "tar.zst", compression: " ZSTD : 3 ", want: "tar.zst", codec: Compression{Codec: CodecZstd, Level: 3}},
+		{format: "zip", compression: "", want: "zip", codec: Compression{Codec: CodecDeflate}},
+		{format: "zip", compression: "deflate:5", want: "zip", codec: Compression{Codec: CodecDeflate, Level: 5}},
+		{format: "zip", compression: "gzip:5", want: "zip", codec: Compression{Codec: CodecDeflate, Level: 5}},
+		{format: "", compression: "deflate", want: "zip", codec: Compression{Codec: CodecDeflate}},
+		{format: "tar", compression: "deflate", err: "format tar cannot use compression deflate"},
+		{format: "tar.gz", compression: "deflate:1", err: "format tar.gz cannot use compression deflate"},
+		{compression: "deflate:10", err: "deflate level must be between 1 and 9, or 0 for the default"},
+		{format: "zip", compression: "none", want: "zip", codec: Compression{Codec: CodecNone}},
+		{format: "zip", compression: "zstd", err: "format zip cannot use compression zstd"},
+		{format: "tar.gz", compression: "zstd", err: "format tar.gz cannot use compression zstd"},
+		{format: "tar.zst", compression: "none", err: "format tar.zst cannot use compression none"},
+		{format: "rar", compression: "", err: `unsupported archive format "rar"`},
+		{compression: "gzip:0", want: "tar.gz", codec: Compression{Codec: CodecGzip}},
+		{compression: "gzip:10", err: "gzip level must be between 1 and 9"},
+		{compression: "gzip:-1", err: "gzip level must be between 1 and 9"},
+		{compression: "zstd:22", want: "tar.zst", codec: Compression{Codec: CodecZstd, Level: 22}},
+		{compression: "zstd:0", want: "tar.zst", codec: Compression{Codec: CodecZstd}},
+		{compression: "zstd:23", err: "zstd level must be between 1 and 22, or 0 for the default"},
+		{compression: "zstd:-1", err: "zstd level must be between 1 and 22, or 0 for the default"},
+		{compression: "none:1", err: "compression none does not take a level"},
+		{compression: "gzip:fast", err: `invalid compression level in "gzip:fast"`},
+		{compression: "brotli", err: `unsupported compression "brotli"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format+"+"+tt.compression, func(t *testing.T) {
+			f, c, err := Resolve(tt.format, tt.compression)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Name != tt.want || c != tt.codec {
+				t.Errorf("got %s with %+v, want %s with %+v", f.Name, c, tt.want, tt.codec)
+			}
+		})
+	}
+}
+
+type benchFile struct {
+	name string
+	data []byte
+}
+
+// readTree reads the regular files under dir.
+func readTree(b *testing.B, dir string) []benchFile {
+	b.Helper()
+	var files []benchFile
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files = append(files, benchFile{name: filepath.ToSlash(rel), data: data})
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return files
+}
+
+// syntheticTree returns text-like files, which compress well, and random
+// ones, which do not, for about 8 MiB in total.
+func syntheticTree() []benchFile {
+	rng := rand.New(rand.NewSource(1))
+	words := strings.Fields("package archive func return error nil if for range string byte int const var type struct")
+	var files []benchFile
+	for i := 0; i < 64; i++ {
+		var buf bytes.Buffer
+		for buf.Len() < 96<<10 {
+			buf.WriteString(words[rng.Intn(len(words))])
+			buf.WriteByte(" \n"[rng.Intn(8)/7])
+		}
+		files = append(files, benchFile{name: fmt.Sprintf("src/file%02d.go", i), data: buf.Bytes()})
+	}
+	for i := 0; i < 8; i++ {
+		data := make([]byte, 256<<10)
+		rng.Read(data)
+		files = append(files, benchFile{name: fmt.Sprintf("bin/blob%d", i), data: data})
+	}
+	return files
+}
+
+func writeTree(files []benchFile, format *Format, c Compression, w io.Writer) error {
+	aw, err := format.NewWriter(w, c)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := aw.WriteHeader(&Header{Name: f.name, Type: TypeFile, Mode: 0o644, Size: int64(len(f.data))}); err != nil {
+			return err
+		}
+		if _, err := aw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	return aw.Close()
+}
+
+// BenchmarkCompression writes the testdata trees and a synthetic tree with
+// each codec and reports the archive size relative to the input.
+func BenchmarkCompression(b *testing.B) {
+	trees := []struct {
+		name  string
+		files []benchFile
+	}{
+		{"testdata", readTree(b, filepath.Join("..", "..", "testdata"))},
+		{"synthetic", syntheticTree()},
+	}
+	for _, tree := range trees {
+		var size int64
+		for _, f := range tree.files {
+			size += int64(len(f.data))
+		}
+		for _, setting := range []string{"none", "gzip:1", "gzip", "gzip:9", "zstd:1", "zstd:3", "zstd:19"} {
+			format, c, err := Resolve("", setting)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(tree.name+"/"+setting, func(b *testing.B) {
+				b.SetBytes(size)
+				var out countingWriter
+				for i := 0; i < b.N; i++ {
+					out = 0
+					if err := writeTree(tree.files, format, c, &out); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(out)/float64(max(size, 1)), "ratio")
+			})
+		}
+	}
+}
+
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,168 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+)
+
+func init() {
+	for _, codec := range []string{CodecGzip, CodecZstd, CodecNone} {
+		name := tarFormatByCodec[codec]
+		register(&Format{
+			Name:      name,
+			Extension: "." + name,
+			Codec:     codec,
+			Codecs:    []string{codec},
+			Hardlinks: true,
+			Streaming: true,
+			NewWriter: newTarWriter,
+			Open:      openTar,
+		})
+	}
+}
+
+func newTarWriter(w io.Writer, c Compression) (Writer, error) {
+	cw, err := compressWriter(w, c)
+	if err != nil {
+		return nil, err
+	}
+	return &tarWriter{tw: tar.NewWriter(cw), closer: cw}, nil
+}
+
+func openTar(path string) (Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newTarReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	r.closers = append(r.closers, file)
+	return r, nil
+}
+
+// NewTarReader reads a tar stream of any supported compression, e.g. one
+// coming over ssh. Closing the reader does not close r.
+func NewTarReader(r io.Reader) (Reader, error) {
+	return newTarReader(r)
+}
+
+func newTarReader(r io.Reader) (*tarReader, error) {
+	dr, closer, err := decompressReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var closers []io.Closer
+	if closer != nil {
+		closers = append(closers, closer)
+	}
+	return &tarReader{tr: tar.NewReader(dr), closers: closers}, nil
+}
+
+type tarWriter struct {
+	tw     *tar.Writer
+	closer io.Closer
+}
+
+func (w *tarWriter) WriteHeader(h *Header) error {
+	header := &tar.Header{
+		Name:    h.Name,
+		Mode:    h.Mode,
+		Size:    h.Size,
+		ModTime: h.ModTime,
+		Uid:     h.Uid,
+		Gid:     h.Gid,
+		Uname:   h.Uname,
+		Gname:   h.Gname,
+		Format:  tar.FormatPAX,
+	}
+	switch h.Type {
+	case TypeFile:
+		header.Typeflag = tar.TypeReg
+	case TypeDir:
+		header.Typeflag = tar.TypeDir
+		header.Size = 0
+		if header.Name != "" && header.Name[len(header.Name)-1] != '/' {
+			header.Name += "/"
+		}
+	case TypeSymlink:
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = h.Linkname
+		header.Size = 0
+	case TypeHardlink:
+		header.Typeflag = tar.TypeLink
+		header.Linkname = h.Linkname
+		header.Size = 0
+	default:
+		return fmt.Errorf("tar: unsupported entry type for %s", h.Name)
+	}
+	return w.tw.WriteHeader(header)
+}
+
+func (w *tarWriter) Write(p []byte) (int, error) {
+	return w.tw.Write(p)
+}
+
+func (w *tarWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	return w.closer.Close()
+}
+
+type tarReader struct {
+	tr      *tar.Reader
+	closers []io.Closer
+}
+
+func (r *tarReader) Next() (*Header, error) {
+	for {
+		header, err := r.tr.Next()
+		if err != nil {
+			return nil, err
+		}
+		h := &Header{
+			Name:     header.Name,
+			Linkname: header.Linkname,
+			Mode:     header.Mode,
+			Size:     header.Size,
+			ModTime:  header.ModTime,
+			Uid:      header.Uid,
+			Gid:      header.Gid,
+			Uname:    header.Uname,
+			Gname:    header.Gname,
+		}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			h.Type = TypeFile
+		case tar.TypeDir:
+			h.Type = TypeDir
+		case tar.TypeSymlink:
+			h.Type = TypeSymlink
+		case tar.TypeLink:
+			h.Type = TypeHardlink
+		default:
+			// ignore other types
+			continue
+		}
+		return h, nil
+	}
+}
+
+func (r *tarReader) Read(p []byte) (int, error) {
+	return r.tr.Read(p)
+}
+
+func (r *tarReader) Close() error {
+	var first error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,159 @@
+package archive
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+func init() {
+	register(&Format{
+		Name:      "zip",
+		Extension: ".zip",
+		Codec:     CodecDeflate,
+		Codecs:    []string{CodecDeflate, CodecNone},
+		NewWriter: func(w io.Writer, c Compression) (Writer, error) {
+			zw := zip.NewWriter(w)
+			method := zip.Deflate
+			if c.Codec == CodecNone {
+				method = zip.Store
+			} else if c.Level != 0 {
+				level := c.Level
+				zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+					return flate.NewWriter(out, level)
+				})
+			}
+			return &zipWriter{zw: zw, method: method}, nil
+		},
+		Open: func(path string) (Reader, error) {
+			zr, err := zip.OpenReader(path)
+			if err != nil {
+				return nil, err
+			}
+			return &zipReader{zr: zr}, nil
+		},
+	})
+}
+
+type zipWriter struct {
+	zw     *zip.Writer
+	method uint16
+	cur    io.Writer
+}
+
+func (w *zipWriter) WriteHeader(h *Header) error {
+	header := &zip.FileHeader{
+		Name:     h.Name,
+		Method:   w.method,
+		Modified: h.ModTime,
+	}
+	switch h.Type {
+	case TypeFile:
+		header.SetMode(fs.FileMode(h.Mode).Perm())
+	case TypeDir:
+		if !strings.HasSuffix(header.Name, "/") {
+			header.Name += "/"
+		}
+		header.Method = zip.Store
+		header.SetMode(fs.ModeDir | fs.FileMode(h.Mode).Perm())
+	case TypeSymlink:
+		// zip stores the link target as the entry's content.
+		header.Method = zip.Store
+		header.SetMode(fs.ModeSymlink | 0o777)
+	default:
+		return fmt.Errorf("zip: unsupported entry type for %s", h.Name)
+	}
+	cur, err := w.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	w.cur = cur
+	if h.Type == TypeSymlink {
+		if _, err := io.WriteString(cur, h.Linkname); err != nil {
+			return err
+		}
+		w.cur = nil
+	}
+	return nil
+}
+
+func (w *zipWriter) Write(p []byte) (int, error) {
+	if w.cur == nil {
+		return 0, fmt.Errorf("zip: write before header")
+	}
+	return w.cur.Write(p)
+}
+
+func (w *zipWriter) Close() error {
+	return w.zw.Close()
+}
+
+type zipReader struct {
+	zr   *zip.ReadCloser
+	next int
+	cur  io.ReadCloser
+}
+
+func (r *zipReader) Next() (*Header, error) {
+	if r.cur != nil {
+		r.cur.Close()
+		r.cur = nil
+	}
+	for r.next < len(r.zr.File) {
+		f := r.zr.File[r.next]
+		r.next++
+
+		mode := f.Mode()
+		h := &Header{
+			Name:    f.Name,
+			Mode:    int64(mode.Perm()),
+			ModTime: f.Modified,
+		}
+		switch {
+		case mode.IsDir():
+			h.Type = TypeDir
+		case mode.IsRegular():
+			h.Type = TypeFile
+			h.Size = int64(f.UncompressedSize64)
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			r.cur = rc
+		case mode&fs.ModeSymlink != 0:
+			h.Type = TypeSymlink
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			target, err := io.ReadAll(io.LimitReader(rc, 4096))
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+			h.Linkname = string(target)
+		default:
+			// ignore other types
+			continue
+		}
+		return h, nil
+	}
+	return nil, io.EOF
+}
+
+func (r *zipReader) Read(p []byte) (int, error) {
+	if r.cur == nil {
+		return 0, io.EOF
+	}
+	return r.cur.Read(p)
+}
+
+func (r *zipReader) Close() error {
+	if r.cur != nil {
+		r.cur.Close()
+	}
+	return r.zr.Close()
+}
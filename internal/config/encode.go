@@ -0,0 +1,377 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RenderSpec returns a spec with extends and include resolved, as JSON,
+// YAML or TOML. ${...} substitutions are left as written.
+func RenderSpec(path, format string) ([]byte, error) {
+	root, _, err := loadSpecTree(path)
+	if err != nil {
+		return nil, err
+	}
+	return encodeSpec(root.normalize(specShapes[specKind(root)]), format)
+}
+
+// FormatSpec returns a spec file in canonical form and the format it was
+// written in. Known keys are put in a fixed order, followed by the others
+// as written; extends, include and ${...} substitutions are kept. to
+// converts the spec to another format, "" keeps its own. Comments are not
+// kept, and YAML aliases are expanded.
+func FormatSpec(path, to string) ([]byte, string, error) {
+	root, format, err := parseSpecFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if root.Kind != MapNode {
+		return nil, "", posErrorf(root.Pos, "spec must be a map")
+	}
+	if to == "" {
+		to = format
+	}
+	s := specShapes[specKind(root)]
+	data, err := encodeSpec(root.normalize(s), to)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The result must read back as the same spec, including which targets
+	// are strings and which are objects.
+	want, err := root.decode(s, "")
+	if err != nil {
+		return nil, "", err
+	}
+	parse := map[string]func(string, []byte) (*Node, error){
+		formatJSON: parseJSON,
+		formatYAML: parseYAML,
+		formatTOML: parseTOML,
+	}[to]
+	out, err := parse(path, data)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: formatted spec does not parse: %w", path, err)
+	}
+	got, err := out.decode(s, "")
+	if err != nil || !reflect.DeepEqual(got, want) {
+		return nil, "", fmt.Errorf("%s: spec changes when written as %s", path, to)
+	}
+	return data, format, nil
+}
+
+func encodeSpec(root *Node, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch format {
+	case formatJSON:
+		writeJSON(&buf, root, "")
+		buf.WriteByte('\n')
+	case formatYAML:
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(root.yamlNode()); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+	case formatTOML:
+		if err := writeTOMLTable(&buf, root, nil); err != nil {
+			return nil, err
+		}
+		return bytes.TrimPrefix(buf.Bytes(), []byte{'\n'}), nil
+	default:
+		return nil, fmt.Errorf("unsupported spec format %q, expected %s, %s or %s", format, formatJSON, formatYAML, formatTOML)
+	}
+	return buf.Bytes(), nil
+}
+
+// normalize returns a copy of the tree in which the scalars the schema
+// expects to be strings are strings, so that a version written as 1.10 is
+// written back as "1.10", and known keys are in the schema's order.
+func (n *Node) normalize(s *shape) *Node {
+	out := *n
+	switch n.Kind {
+	case ScalarNode:
+		if s != nil && s.scalar == scalarString {
+			out.Type = StringScalar
+		}
+	case ListNode:
+		var items *shape
+		if s != nil {
+			items = s.items
+		}
+		out.Items = make([]*Node, len(n.Items))
+		for i, item := range n.Items {
+			out.Items[i] = item.normalize(items)
+		}
+	case MapNode:
+		out.Fields = make([]*Field, len(n.Fields))
+		rank := map[string]int{}
+		for i, f := range n.Fields {
+			var field *shape
+			if s != nil {
+				field = s.fields[f.Key]
+				rank[f.Key] = len(s.order)
+				if i := slices.Index(s.order, f.Key); i >= 0 {
+					rank[f.Key] = i
+				}
+			}
+			out.Fields[i] = &Field{Key: f.Key, KeyPos: f.KeyPos, Value: f.Value.normalize(field)}
+		}
+		sort.SliceStable(out.Fields, func(i, j int) bool {
+			return rank[out.Fields[i].Key] < rank[out.Fields[j].Key]
+		})
+	}
+	return &out
+}
+
+// canonical returns a scalar as JSON and YAML both read it: ints in
+// decimal, floats without "_", lowercase booleans. Values JSON cannot hold,
+// such as inf, are returned as strings.
+func (n *Node) canonical() (string, ScalarType) {
+	switch n.Type {
+	case BoolScalar:
+		return strings.ToLower(n.Value), BoolScalar
+	case IntScalar:
+		if i, err := parseInt(n.Value); err == nil {
+			return strconv.FormatInt(i, 10), IntScalar
+		}
+	case FloatScalar:
+		if f, err := strconv.ParseFloat(strings.ReplaceAll(n.Value, "_", ""), 64); err == nil {
+			if s := strconv.FormatFloat(f, 'g', -1, 64); !strings.ContainsAny(s, "IN") {
+				return s, FloatScalar
+			}
+		}
+	}
+	return n.Value, StringScalar
+}
+
+func writeJSON(buf *bytes.Buffer, n *Node, indent string) {
+	inner := indent + "  "
+	switch n.Kind {
+	case NullNode:
+		buf.WriteString("null")
+	case ScalarNode:
+		value, typ := n.canonical()
+		if typ == StringScalar {
+			value = jsonString(value)
+		}
+		buf.WriteString(value)
+	case ListNode:
+		if len(n.Items) == 0 {
+			buf.WriteString("[]")
+			return
+		}
+		buf.WriteString("[\n")
+		for i, item := range n.Items {
+			buf.WriteString(inner)
+			writeJSON(buf, item, inner)
+			if i < len(n.Items)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(indent + "]")
+	case MapNode:
+		if len(n.Fields) == 0 {
+			buf.WriteString("{}")
+			return
+		}
+		buf.WriteString("{\n")
+		for i, f := range n.Fields {
+			buf.WriteString(inner)
+			buf.WriteString(jsonString(f.Key))
+			buf.WriteString(": ")
+			writeJSON(buf, f.Value, inner)
+			if i < len(n.Fields)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(indent + "}")
+	}
+}
+
+// jsonString quotes s without the HTML escaping of json.Marshal, which
+// would turn ">=1.0" into "\u003e=1.0".
+func jsonString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// writeTOMLTable writes the keys of a map that TOML takes as values, then
+// its maps as [tables] and its lists of maps as [[arrays of tables]], which
+// TOML requires to come last.
+func writeTOMLTable(buf *bytes.Buffer, m *Node, path []string) error {
+	var tables []*Field
+	for _, f := range m.Fields {
+		if f.Value.Kind == MapNode || isTableArray(f.Value) {
+			tables = append(tables, f)
+			continue
+		}
+		buf.WriteString(tomlKey(f.Key) + " = ")
+		if err := writeTOMLValue(buf, f.Value, false); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+	}
+	for _, f := range tables {
+		sub := append(path[:len(path):len(path)], tomlKey(f.Key))
+		header := strings.Join(sub, ".")
+		if f.Value.Kind == MapNode {
+			buf.WriteString("\n[" + header + "]\n")
+			if err := writeTOMLTable(buf, f.Value, sub); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, item := range f.Value.Items {
+			buf.WriteString("\n[[" + header + "]]\n")
+			if err := writeTOMLTable(buf, item, sub); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isTableArray(n *Node) bool {
+	if n.Kind != ListNode || len(n.Items) == 0 {
+		return false
+	}
+	for _, item := range n.Items {
+		if item.Kind != MapNode {
+			return false
+		}
+	}
+	return true
+}
+
+// writeTOMLValue writes a value; lists that hold maps or lists are split
+// over several lines unless inline is set.
+func writeTOMLValue(buf *bytes.Buffer, n *Node, inline bool) error {
+	switch n.Kind {
+	case NullNode:
+		return posErrorf(n.Pos, "null cannot be written as TOML")
+	case ScalarNode:
+		value, typ := n.canonical()
+		switch {
+		case typ == StringScalar:
+			value = tomlString(value)
+		case typ == FloatScalar && !strings.ContainsAny(value, ".e"):
+			value += ".0"
+		}
+		buf.WriteString(value)
+	case ListNode:
+		multiline := !inline && slices.ContainsFunc(n.Items, func(item *Node) bool { return item.Kind != ScalarNode })
+		buf.WriteByte('[')
+		for i, item := range n.Items {
+			switch {
+			case multiline:
+				buf.WriteString("\n  ")
+			case i > 0:
+				buf.WriteString(", ")
+			}
+			if err := writeTOMLValue(buf, item, true); err != nil {
+				return err
+			}
+			if multiline {
+				buf.WriteByte(',')
+			}
+		}
+		if multiline {
+			buf.WriteByte('\n')
+		}
+		buf.WriteByte(']')
+	case MapNode:
+		buf.WriteString("{")
+		for i, f := range n.Fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(" " + tomlKey(f.Key) + " = ")
+			if err := writeTOMLValue(buf, f.Value, true); err != nil {
+				return err
+			}
+		}
+		buf.WriteString(" }")
+	}
+	return nil
+}
+
+var bareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func tomlKey(key string) string {
+	if bareKey.MatchString(key) {
+		return key
+	}
+	return tomlString(key)
+}
+
+// tomlString quotes s as a TOML basic string. strconv.Quote is close, but
+// uses escapes such as \x00 that TOML does not have.
+func tomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u%04X`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+var yamlTags = map[ScalarType]string{
+	StringScalar: "!!str",
+	IntScalar:    "!!int",
+	FloatScalar:  "!!float",
+	BoolScalar:   "!!bool",
+}
+
+func (n *Node) yamlNode() *yaml.Node {
+	switch n.Kind {
+	case ListNode:
+		out := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, item := range n.Items {
+			out.Content = append(out.Content, item.yamlNode())
+		}
+		return out
+	case MapNode:
+		out := &yaml.Node{Kind: yaml.MappingNode}
+		for _, f := range n.Fields {
+			key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: f.Key}
+			out.Content = append(out.Content, key, f.Value.yamlNode())
+		}
+		return out
+	case ScalarNode:
+		value, typ := n.canonical()
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: yamlTags[typ], Value: value}
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+	}
+}
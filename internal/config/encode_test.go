@@ -0,0 +1,193 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// formatted holds one spec in canonical form in each format. Targets and
+// packets mix strings and objects, and exclude is a string in one target,
+// which every format must keep.
+var formatted = map[string]string{
+	formatJSON: `{
+  "extends": "../base.yaml",
+  "name": "app",
+  "ver": "1.10",
+  "description": "v${file:VERSION} # not a comment",
+  "targets": [
+    "bin/*",
+    {
+      "path": [
+        "lib/*.so",
+        "!lib/*.a"
+      ],
+      "exclude": "*.debug",
+      "mode": "0755",
+      "min_files": 2
+    }
+  ],
+  "packets": [
+    "zlib 1.3",
+    {
+      "name": "libc",
+      "ver": ">=2",
+      "optional": true
+    }
+  ],
+  "x-note": "kept"
+}
+`,
+	formatYAML: `extends: ../base.yaml
+name: app
+ver: "1.10"
+description: 'v${file:VERSION} # not a comment'
+targets:
+  - bin/*
+  - path:
+      - lib/*.so
+      - '!lib/*.a'
+    exclude: '*.debug'
+    mode: "0755"
+    min_files: 2
+packets:
+  - zlib 1.3
+  - name: libc
+    ver: '>=2'
+    optional: true
+x-note: kept
+`,
+	formatTOML: `extends = "../base.yaml"
+name = "app"
+ver = "1.10"
+description = "v${file:VERSION} # not a comment"
+targets = [
+  "bin/*",
+  { path = ["lib/*.so", "!lib/*.a"], exclude = "*.debug", mode = "0755", min_files = 2 },
+]
+packets = [
+  "zlib 1.3",
+  { name = "libc", ver = ">=2", optional = true },
+]
+x-note = "kept"
+`,
+}
+
+func writeSpec(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func formatSpec(t *testing.T, path, to string) string {
+	t.Helper()
+	data, _, err := FormatSpec(path, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestFormatSpecRoundTrip converts every format into every other one; a
+// formatted spec formats to itself, which is what pm fmt --check relies on.
+func TestFormatSpecRoundTrip(t *testing.T) {
+	for from, src := range formatted {
+		for to, want := range formatted {
+			t.Run(from+"-to-"+to, func(t *testing.T) {
+				path := writeSpec(t, "pm."+from, src)
+				if got := formatSpec(t, path, to); got != want {
+					t.Errorf("got\n%s\nwant\n%s", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestFormatSpecFromUnformatted(t *testing.T) {
+	path := writeSpec(t, "pm.yaml", `# comments are dropped
+targets:
+  - bin/*
+  - path: [lib/*.so, "!lib/*.a"]
+    min_files: 2
+    mode: 0755
+    exclude: "*.debug"
+ver: 1.10
+name: app
+x-note: kept
+packets: [zlib 1.3, {ver: ">=2", name: libc, optional: true}]
+extends: ../base.yaml
+description: "v${file:VERSION} # not a comment"
+`)
+	for to, want := range formatted {
+		t.Run(to, func(t *testing.T) {
+			data, format, err := FormatSpec(path, to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != formatYAML {
+				t.Errorf("got source format %s, want %s", format, formatYAML)
+			}
+			if string(data) != want {
+				t.Errorf("got\n%s\nwant\n%s", data, want)
+			}
+		})
+	}
+}
+
+func TestFormatSpecTOMLTables(t *testing.T) {
+	path := writeSpec(t, "pm.json", `{
+  "targets": [{"path": "bin/*", "dest": "usr/bin"}, {"path": "etc/*"}],
+  "scripts": {"post_install": "post.sh", "pre_install": "pre.sh"},
+  "name": "app", "ver": "1", "x-min": 1.0, "x-big": 1e21, "x-esc": "tab\t\"q\"\u0001"
+}`)
+	want := `name = "app"
+ver = "1"
+x-min = 1.0
+x-big = 1e+21
+x-esc = "tab\t\"q\"\u0001"
+
+[[targets]]
+path = "bin/*"
+dest = "usr/bin"
+
+[[targets]]
+path = "etc/*"
+
+[scripts]
+pre_install = "pre.sh"
+post_install = "post.sh"
+`
+	if got := formatSpec(t, path, formatTOML); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatSpecErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		src  string
+		to   string
+		err  string
+	}{
+		{name: "null in TOML", file: "pm.json", src: `{"name": "app", "description": null}`, to: formatTOML, err: "pm.json:1:32: null cannot be written as TOML"},
+		{name: "unknown format", file: "pm.json", src: `{"name": "app"}`, to: "xml", err: `unsupported spec format "xml"`},
+		{name: "not a map", file: "pm.json", src: `["app"]`, err: "pm.json:1:1: spec must be a map"},
+		{name: "parse error", file: "pm.yaml", src: "name: a\nname: b\n", err: `pm.yaml:2:1: duplicate key "name"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := FormatSpec(writeSpec(t, tt.file, tt.src), tt.to)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
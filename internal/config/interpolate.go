@@ -0,0 +1,231 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// interpolator expands ${...} references in spec values:
+//
+//	${VAR}          environment variable (the CLI loads .env into it)
+//	${file:PATH}    trimmed contents of PATH, relative to the spec file
+//	${git:describe} latest tag reachable from HEAD, without a leading "v"
+//
+// "$${" produces a literal "${". A value inherited through extends or
+// include resolves file and git references from the file that wrote it.
+type interpolator struct {
+	dir string
+	// root is the merged spec tree, whose positions tell which file each
+	// value came from.
+	root  *Node
+	cache map[[2]string]string
+}
+
+func newInterpolator(specPath string, root *Node) *interpolator {
+	return &interpolator{dir: filepath.Dir(specPath), root: root, cache: map[[2]string]string{}}
+}
+
+// dirOf returns the directory of the file that set field, a path like
+// "targets[0].path[1]". Values the tree does not hold, such as workspace
+// defaults, belong to the spec itself.
+func (ip *interpolator) dirOf(field string) string {
+	n := ip.root
+	for _, part := range strings.Split(field, ".") {
+		key, index, _ := strings.Cut(part, "[")
+		switch {
+		case n == nil:
+			return ip.dir
+		case n.Kind == MapNode:
+			n = n.Get(key)
+		case n.Kind == ScalarNode:
+			// A target or dependency written as a string stands for all
+			// of its keys, so the path stays on it.
+		default:
+			return ip.dir
+		}
+		if index == "" || n == nil {
+			continue
+		}
+		i, err := strconv.Atoi(strings.TrimSuffix(index, "]"))
+		switch {
+		case err != nil:
+			return ip.dir
+		case n.Kind == ListNode && i < len(n.Items):
+			n = n.Items[i]
+		case n.Kind == ListNode:
+			n = nil
+		}
+	}
+	if n == nil || n.Pos.File == "" {
+		return ip.dir
+	}
+	return filepath.Dir(n.Pos.File)
+}
+
+func (ip *interpolator) expand(field, value string) (string, error) {
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+	var b strings.Builder
+	rest := value
+	for {
+		idx := strings.Index(rest, "${")
+		if idx == -1 {
+			b.WriteString(rest)
+			return b.String(), nil
+		}
+		if idx > 0 && rest[idx-1] == '$' {
+			b.WriteString(rest[:idx-1])
+			b.WriteString("${")
+			rest = rest[idx+2:]
+			continue
+		}
+		b.WriteString(rest[:idx])
+		end := strings.IndexByte(rest[idx:], '}')
+		if end == -1 {
+			return "", fmt.Errorf("%s: unterminated ${ in %q", field, value)
+		}
+		ref := rest[idx+2 : idx+end]
+		resolved, err := ip.resolve(ip.dirOf(field), ref)
+		if err != nil {
+			return "", fmt.Errorf("%s: ${%s}: %w", field, ref, err)
+		}
+		b.WriteString(resolved)
+		rest = rest[idx+end+1:]
+	}
+}
+
+func (ip *interpolator) resolve(dir, ref string) (string, error) {
+	key := [2]string{dir, ref}
+	if v, ok := ip.cache[key]; ok {
+		return v, nil
+	}
+	source, arg, hasSource := strings.Cut(ref, ":")
+	var (
+		v   string
+		err error
+	)
+	switch {
+	case !hasSource:
+		if ref == "" {
+			return "", errors.New("empty variable name")
+		}
+		var ok bool
+		if v, ok = os.LookupEnv(ref); !ok {
+			return "", errors.New("variable is not set in the environment or .env")
+		}
+	case source == "file":
+		v, err = readFile(dir, arg)
+	case source == "git":
+		v, err = gitDescribe(dir, arg)
+	default:
+		return "", fmt.Errorf("unknown source %q", source)
+	}
+	if err != nil {
+		return "", err
+	}
+	ip.cache[key] = v
+	return v, nil
+}
+
+func readFile(dir, name string) (string, error) {
+	if name == "" {
+		return "", errors.New("missing file name")
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func gitDescribe(dir, query string) (string, error) {
+	if query != "describe" {
+		return "", fmt.Errorf("unsupported git query %q (only describe)", query)
+	}
+	cmd := exec.Command("git", "describe", "--tags")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("git describe failed: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("git describe failed: %w", err)
+	}
+	desc := strings.TrimPrefix(strings.TrimSpace(string(out)), "v")
+	// Archive names are <name>-<version>, so a describe result like
+	// 1.2.0-3-gabc123 would not survive the round trip through update.
+	if strings.Contains(desc, "-") {
+		return "", fmt.Errorf("HEAD is not tagged (git describe gives %s); tag the release commit", desc)
+	}
+	return desc, nil
+}
+
+type specField struct {
+	name  string
+	value *string
+}
+
+func (ip *interpolator) expandAll(fields []specField) error {
+	for _, f := range fields {
+		expanded, err := ip.expand(f.name, *f.value)
+		if err != nil {
+			return err
+		}
+		*f.value = expanded
+	}
+	return nil
+}
+
+func (spec *PackageSpec) interpolate(ip *interpolator) error {
+	fields := []specField{{"name", &spec.Name}, {"ver", &spec.Version}}
+	for i := range spec.Targets {
+		target := &spec.Targets[i]
+		for j := range target.Patterns {
+			fields = append(fields, specField{fmt.Sprintf("targets[%d].path[%d]", i, j), &target.Patterns[j]})
+		}
+		for j := range target.Exclude {
+			fields = append(fields, specField{fmt.Sprintf("targets[%d].exclude[%d]", i, j), &target.Exclude[j]})
+		}
+		fields = append(fields, specField{fmt.Sprintf("targets[%d].dest", i), &target.Dest})
+		fields = append(fields, specField{fmt.Sprintf("targets[%d].strip_prefix", i), &target.StripPrefix})
+	}
+	fields = append(fields,
+		specField{"scripts.pre_install", &spec.Scripts.PreInstall},
+		specField{"scripts.post_install", &spec.Scripts.PostInstall},
+		specField{"scripts.pre_remove", &spec.Scripts.PreRemove},
+		specField{"scripts.post_remove", &spec.Scripts.PostRemove},
+		specField{"description", &spec.Description},
+		specField{"license", &spec.License},
+		specField{"homepage", &spec.Homepage},
+		specField{"readme", &spec.Readme},
+		specField{"os", &spec.OS},
+		specField{"arch", &spec.Arch},
+	)
+	fields = appendDependencyFields(fields, "packets", spec.Packages)
+	fields = appendDependencyFields(fields, "conflicts", spec.Conflicts)
+	fields = appendDependencyFields(fields, "provides", spec.Provides)
+	fields = appendDependencyFields(fields, "replaces", spec.Replaces)
+	return ip.expandAll(fields)
+}
+
+func (spec *UpdateSpec) interpolate(ip *interpolator) error {
+	return ip.expandAll(appendDependencyFields(nil, "packages", spec.Packages))
+}
+
+func appendDependencyFields(fields []specField, key string, deps []DependencySpec) []specField {
+	for i := range deps {
+		fields = append(fields, specField{fmt.Sprintf("%s[%d].name", key, i), &deps[i].Name})
+		fields = append(fields, specField{fmt.Sprintf("%s[%d].ver", key, i), &deps[i].Version})
+	}
+	return fields
+}
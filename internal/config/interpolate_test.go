@@ -0,0 +1,102 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Values inherited through extends read ${file:...} next to the base spec,
+// values the spec sets itself next to the spec.
+func TestInterpolateInheritedFileReferences(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"base/base.yaml": `ver: "${file:VERSION}"
+description: "${file:DESC}"
+targets: ["${file:TARGET}"]
+packets: ["liba ${file:LIBA}", "libb"]
+`,
+		"base/VERSION": "9.9\n",
+		"base/DESC":    "base",
+		"base/TARGET":  "base-bin/*",
+		"base/LIBA":    ">=1",
+		"app/spec.yaml": `extends: ../base/base.yaml
+name: app
+description: "${file:DESC}"
+targets:
+  - path: own/*
+    dest: "${file:DEST}"
+packets: [{name: libb, ver: "${file:LIBB}"}]
+`,
+		"app/VERSION": "1.2",
+		"app/DESC":    "app",
+		"app/TARGET":  "app-bin/*",
+		"app/DEST":    "opt",
+		"app/LIBB":    "<2",
+	}
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	spec, err := LoadPackageSpec(filepath.Join(root, "app", "spec.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Version != "9.9" {
+		t.Errorf("ver: got %q, want %q", spec.Version, "9.9")
+	}
+	if spec.Description != "app" {
+		t.Errorf("description: got %q, want %q", spec.Description, "app")
+	}
+	wantTargets := []TargetSpec{{Patterns: []string{"base-bin/*"}}, {Patterns: []string{"own/*"}, Dest: "opt"}}
+	if !reflect.DeepEqual(spec.Targets, wantTargets) {
+		t.Errorf("targets: got %+v, want %+v", spec.Targets, wantTargets)
+	}
+	wantPackets := []DependencySpec{{Name: "liba", Version: ">=1"}, {Name: "libb", Version: "<2"}}
+	if !reflect.DeepEqual(spec.Packages, wantPackets) {
+		t.Errorf("packets: got %+v, want %+v", spec.Packages, wantPackets)
+	}
+}
+
+func TestInterpolatorDirOf(t *testing.T) {
+	tree, err := parseYAML("app/spec.yaml", []byte(`
+targets:
+  - bin/*
+  - path: [a, b]
+    dest: d
+packets: [zlib]
+conflicts: {name: old}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Give one nested value another file, as a merge with a base would.
+	tree.Get("targets").Items[1].Get("path").Items[1].Pos.File = "base/base.yaml"
+	ip := newInterpolator("app/spec.yaml", tree)
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{"targets[0].path[0]", "app"},
+		{"targets[0].dest", "app"},
+		{"targets[1].path[0]", "app"},
+		{"targets[1].path[1]", "base"},
+		{"targets[1].dest", "app"},
+		{"targets[2].path[0]", "app"},
+		{"packets[0].ver", "app"},
+		{"conflicts[0].name", "app"},
+		{"description", "app"},
+	}
+	for _, tt := range tests {
+		if got := ip.dirOf(tt.field); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.field, got, tt.want)
+		}
+	}
+}
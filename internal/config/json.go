@@ -0,0 +1,134 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// parseJSON parses a JSON document into a Node tree. Unlike encoding/json it
+// rejects duplicate keys, since only the last one would take effect.
+func parseJSON(path string, data []byte) (*Node, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	p := &jsonParser{path: path, data: data, dec: dec}
+	root, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	off := p.next()
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, posErrorf(p.pos(off), "unexpected data after the top-level value")
+	}
+	return root, nil
+}
+
+type jsonParser struct {
+	path string
+	data []byte
+	dec  *json.Decoder
+}
+
+// next returns the offset of the next token. The decoder reports the offset
+// after the previous one, which may be followed by blanks, ',' or ':'.
+func (p *jsonParser) next() int {
+	off := int(p.dec.InputOffset())
+	for off < len(p.data) && strings.IndexByte(" \t\r\n,:", p.data[off]) >= 0 {
+		off++
+	}
+	return off
+}
+
+func (p *jsonParser) pos(off int) Pos {
+	lead := p.data[:off]
+	return Pos{
+		File:   p.path,
+		Line:   bytes.Count(lead, []byte{'\n'}) + 1,
+		Column: off - bytes.LastIndexByte(lead, '\n'),
+	}
+}
+
+func (p *jsonParser) token() (json.Token, Pos, error) {
+	off := p.next()
+	tok, err := p.dec.Token()
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		isSyntax := errors.As(err, &syntaxErr)
+		switch {
+		case err == io.EOF || err == io.ErrUnexpectedEOF || isSyntax && syntaxErr.Error() == "unexpected end of JSON input":
+			return nil, Pos{}, posErrorf(p.pos(len(p.data)), "unexpected end of JSON input")
+		case isSyntax:
+			// Offset is just past the offending byte.
+			off = max(int(syntaxErr.Offset)-1, 0)
+		}
+		return nil, Pos{}, posErrorf(p.pos(off), "%v", err)
+	}
+	return tok, p.pos(off), nil
+}
+
+func (p *jsonParser) value() (*Node, error) {
+	tok, pos, err := p.token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '[' {
+			return p.list(pos)
+		}
+		return p.object(pos)
+	case string:
+		return &Node{Kind: ScalarNode, Pos: pos, Value: t}, nil
+	case json.Number:
+		n := &Node{Kind: ScalarNode, Pos: pos, Value: t.String(), Type: IntScalar}
+		if strings.ContainsAny(n.Value, ".eE") {
+			n.Type = FloatScalar
+		}
+		return n, nil
+	case bool:
+		return &Node{Kind: ScalarNode, Pos: pos, Value: fmt.Sprint(t), Type: BoolScalar}, nil
+	default:
+		return &Node{Kind: NullNode, Pos: pos}, nil
+	}
+}
+
+func (p *jsonParser) list(pos Pos) (*Node, error) {
+	out := &Node{Kind: ListNode, Pos: pos}
+	for p.dec.More() {
+		item, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		out.Items = append(out.Items, item)
+	}
+	if _, _, err := p.token(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (p *jsonParser) object(pos Pos) (*Node, error) {
+	out := &Node{Kind: MapNode, Pos: pos}
+	for p.dec.More() {
+		tok, keyPos, err := p.token()
+		if err != nil {
+			return nil, err
+		}
+		key := tok.(string)
+		if prev := out.field(key); prev != nil {
+			return nil, posErrorf(keyPos, "duplicate key %q, first set at %s", key, prev.KeyPos)
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		out.Fields = append(out.Fields, &Field{Key: key, KeyPos: keyPos, Value: value})
+	}
+	if _, _, err := p.token(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
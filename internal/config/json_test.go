@@ -0,0 +1,133 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONSpec(t *testing.T) {
+	spec := decodePackage(t, parseJSON, "p.json", `{
+  "name": "app",
+  "ver": 1.10,
+  "description": "a # b",
+  "dotfiles": true,
+  "targets": ["bin/*", {"path": ["lib/*.so", "!lib/*.a"], "mode": "0755", "min_files": 2}],
+  "packets": [{"name": "libc", "ver": ">=2.31"}, "zlib 1.3"]
+}`)
+	if spec.Version != "1.10" {
+		t.Errorf("ver: got %q, want %q", spec.Version, "1.10")
+	}
+	if spec.Description != "a # b" || !spec.Dotfiles {
+		t.Errorf("got %+v", spec)
+	}
+	want := []TargetSpec{
+		{Patterns: []string{"bin/*"}},
+		{Patterns: []string{"lib/*.so", "!lib/*.a"}, Mode: 0o755, MinFiles: 2},
+	}
+	if !reflect.DeepEqual(spec.Targets, want) {
+		t.Errorf("targets: got %+v, want %+v", spec.Targets, want)
+	}
+	if want := []DependencySpec{{Name: "libc", Version: ">=2.31"}, {Name: "zlib", Version: "1.3"}}; !reflect.DeepEqual(spec.Packages, want) {
+		t.Errorf("packets: got %+v, want %+v", spec.Packages, want)
+	}
+}
+
+func TestJSONPositions(t *testing.T) {
+	root, err := parseJSON("p.json", []byte("{\n  \"name\" :  \"app\",\n\t\"targets\": [ \"a\",{\"path\":\"b\"} ],\n  \"n\": -1.5e3, \"x\": null\n}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := root.Get("targets")
+	tests := []struct {
+		what string
+		pos  Pos
+		want string
+	}{
+		{"root", root.Pos, "p.json:1:1"},
+		{"name key", root.Fields[0].KeyPos, "p.json:2:3"},
+		{"name", root.Get("name").Pos, "p.json:2:13"},
+		{"targets", targets.Pos, "p.json:3:13"},
+		{"targets[0]", targets.Items[0].Pos, "p.json:3:15"},
+		{"targets[1]", targets.Items[1].Pos, "p.json:3:19"},
+		{"targets[1].path", targets.Items[1].Get("path").Pos, "p.json:3:27"},
+		{"n", root.Get("n").Pos, "p.json:4:8"},
+		{"x", root.Get("x").Pos, "p.json:4:21"},
+	}
+	for _, tt := range tests {
+		if got := tt.pos.String(); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.what, got, tt.want)
+		}
+	}
+	if n := root.Get("n"); n.Type != FloatScalar || n.Value != "-1.5e3" {
+		t.Errorf("n: got %+v", n)
+	}
+	if x := root.Get("x"); x.Kind != NullNode {
+		t.Errorf("x: got %+v", x)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{name: "duplicate key", src: "{\n  \"name\": \"a\",\n  \"name\": \"b\"\n}", err: `p.json:3:3: duplicate key "name", first set at p.json:2:3`},
+		{name: "nested duplicate key", src: `{"targets": [{"path": "a", "path": "b"}]}`, err: `p.json:1:28: duplicate key "path", first set at p.json:1:15`},
+		{name: "trailing comma", src: "{\n  \"name\": \"a\",\n}", err: "p.json:2:14: invalid character ',' looking for beginning of value"},
+		{name: "missing colon", src: "{\n  \"name\" \"a\"\n}", err: `p.json:2:10: invalid character '"' after object key`},
+		{name: "bad literal", src: `{"dotfiles": tru}`, err: "p.json:1:17: invalid character '}' in literal true"},
+		{name: "newline in string", src: "{\"name\": \"a\nb\"}", err: `p.json:1:12: invalid character '\n' in string`},
+		{name: "unterminated", src: "{\n  \"name\": \"a\"\n", err: "p.json:3:1: unexpected end of JSON input"},
+		{name: "trailing data", src: "{}\n{}", err: "p.json:2:1: unexpected data after the top-level value"},
+		{name: "empty", src: "", err: "p.json:1:1: unexpected end of JSON input"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseJSON("p.json", []byte(tt.src))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// Files without a known extension are read as JSON, then TOML, then YAML.
+func TestParseSpecFileFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		format string
+	}{
+		{name: "pm.json", src: `{"name": "a"}`, format: formatJSON},
+		{name: "pm.yml", src: "name: a\n", format: formatYAML},
+		{name: "pm.toml", src: "name = \"a\"\n", format: formatTOML},
+		{name: "spec", src: `{"name": "a"}`, format: formatJSON},
+		{name: "spec", src: "name = \"a\"\n", format: formatTOML},
+		{name: "spec", src: "name: a\n", format: formatYAML},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format+"/"+tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.name)
+			if err := os.WriteFile(path, []byte(tt.src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			root, format, err := parseSpecFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != tt.format {
+				t.Errorf("got format %s, want %s", format, tt.format)
+			}
+			if name := root.Get("name"); name == nil || name.Value != "a" {
+				t.Errorf("name: got %+v", name)
+			}
+		})
+	}
+}
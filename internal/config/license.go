@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"buf.build/go/spdx"
+)
+
+// NormalizeLicense checks an SPDX license expression such as "MIT" or
+// "(Apache-2.0 OR MIT) AND BSD-3-Clause" and returns it with the license
+// identifiers in their canonical case. LicenseRef- identifiers are accepted
+// as they are; exceptions after WITH are only checked for syntax.
+func NormalizeLicense(expr string) (string, error) {
+	p := &licenseParser{tokens: tokenizeLicense(expr)}
+	if len(p.tokens) == 0 {
+		return "", fmt.Errorf("empty license expression")
+	}
+	out, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	if err != nil {
+		return "", fmt.Errorf("license %q: %w", expr, err)
+	}
+	return out, nil
+}
+
+type licenseParser struct {
+	tokens []string
+	pos    int
+}
+
+func tokenizeLicense(expr string) []string {
+	expr = strings.NewReplacer("(", " ( ", ")", " ) ").Replace(expr)
+	return strings.Fields(expr)
+}
+
+func (p *licenseParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *licenseParser) parseOr() (string, error) {
+	return p.parseOperator("OR", p.parseAnd)
+}
+
+func (p *licenseParser) parseAnd() (string, error) {
+	return p.parseOperator("AND", p.parseWith)
+}
+
+func (p *licenseParser) parseOperator(op string, operand func() (string, error)) (string, error) {
+	out, err := operand()
+	if err != nil {
+		return "", err
+	}
+	for strings.EqualFold(p.peek(), op) {
+		p.pos++
+		next, err := operand()
+		if err != nil {
+			return "", err
+		}
+		out += " " + op + " " + next
+	}
+	return out, nil
+}
+
+func (p *licenseParser) parseWith() (string, error) {
+	out, err := p.parseTerm()
+	if err != nil {
+		return "", err
+	}
+	if strings.EqualFold(p.peek(), "WITH") {
+		p.pos++
+		exception := p.peek()
+		if !isLicenseWord(exception) {
+			return "", fmt.Errorf("missing exception after WITH")
+		}
+		p.pos++
+		out += " WITH " + exception
+	}
+	return out, nil
+}
+
+func (p *licenseParser) parseTerm() (string, error) {
+	tok := p.peek()
+	switch {
+	case tok == "":
+		return "", fmt.Errorf("unexpected end of expression")
+	case tok == "(":
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return "", err
+		}
+		if p.peek() != ")" {
+			return "", fmt.Errorf("missing )")
+		}
+		p.pos++
+		return "(" + inner + ")", nil
+	case !isLicenseWord(tok):
+		return "", fmt.Errorf("unexpected %q", tok)
+	}
+	p.pos++
+	return licenseID(tok)
+}
+
+func isLicenseWord(tok string) bool {
+	if tok == "" || tok == "(" || tok == ")" {
+		return false
+	}
+	for _, op := range []string{"AND", "OR", "WITH"} {
+		if strings.EqualFold(tok, op) {
+			return false
+		}
+	}
+	return true
+}
+
+// licenseID looks up one identifier, allowing the "or later" suffix "+".
+func licenseID(tok string) (string, error) {
+	if strings.HasPrefix(tok, "LicenseRef-") || strings.HasPrefix(tok, "DocumentRef-") {
+		return tok, nil
+	}
+	plus := strings.HasSuffix(tok, "+")
+	license, ok := spdx.LicenseForID(strings.TrimSuffix(tok, "+"))
+	if !ok {
+		return "", fmt.Errorf("%s is not an SPDX license identifier (see https://spdx.org/licenses, or use LicenseRef-<name>)", tok)
+	}
+	if plus {
+		return license.ID + "+", nil
+	}
+	return license.ID, nil
+}
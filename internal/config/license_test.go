@@ -0,0 +1,60 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeLicense(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+		err  string
+	}{
+		{expr: "MIT", want: "MIT"},
+		{expr: "mit", want: "MIT"},
+		{expr: "apache-2.0", want: "Apache-2.0"},
+		{expr: "GPL-2.0-or-later", want: "GPL-2.0-or-later"},
+		{expr: "LGPL-2.1+", want: "LGPL-2.1+"},
+		{expr: "MIT OR Apache-2.0", want: "MIT OR Apache-2.0"},
+		{expr: "mit or apache-2.0", want: "MIT OR Apache-2.0"},
+		{expr: "MIT AND BSD-3-Clause AND ISC", want: "MIT AND BSD-3-Clause AND ISC"},
+		{expr: "GPL-2.0-only WITH Classpath-exception-2.0", want: "GPL-2.0-only WITH Classpath-exception-2.0"},
+		{expr: "gpl-2.0-only with Classpath-exception-2.0 OR MIT", want: "GPL-2.0-only WITH Classpath-exception-2.0 OR MIT"},
+		{expr: "(Apache-2.0 OR MIT) AND BSD-3-Clause", want: "(Apache-2.0 OR MIT) AND BSD-3-Clause"},
+		{expr: "  ( mit )  ", want: "(MIT)"},
+		{expr: "((MIT OR ISC) AND (Apache-2.0))", want: "((MIT OR ISC) AND (Apache-2.0))"},
+		{expr: "LicenseRef-Proprietary", want: "LicenseRef-Proprietary"},
+		{expr: "DocumentRef-spdx:LicenseRef-x AND MIT", want: "DocumentRef-spdx:LicenseRef-x AND MIT"},
+
+		{expr: "", err: "empty license expression"},
+		{expr: "   ", err: "empty license expression"},
+		{expr: "Foo-1.0", err: `license "Foo-1.0": Foo-1.0 is not an SPDX license identifier`},
+		{expr: "MIT OR Foo", err: "Foo is not an SPDX license identifier"},
+		{expr: "MIT OR", err: "unexpected end of expression"},
+		{expr: "AND MIT", err: `unexpected "AND"`},
+		{expr: "MIT Apache-2.0", err: `unexpected "Apache-2.0"`},
+		{expr: "MIT WITH", err: "missing exception after WITH"},
+		{expr: "MIT WITH (x)", err: "missing exception after WITH"},
+		{expr: "(MIT OR ISC", err: "missing )"},
+		{expr: "MIT)", err: `unexpected ")"`},
+		{expr: "()", err: `unexpected ")"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := NormalizeLicense(tt.expr)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %q, %v, want error %q", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// mergeByName lists the top-level dependency lists; their entries are
+// merged by package name instead of appended.
+var mergeByName = map[string]bool{
+	"packets":   true,
+	"conflicts": true,
+	"provides":  true,
+	"replaces":  true,
+	"packages":  true,
+}
+
+// loadSpecTree parses a spec and resolves its "extends" and "include"
+// directives. The spec named by extends is the base; the include fragments
+// are merged onto it in order, and the spec itself goes last. Paths are
+// relative to the file that names them.
+func loadSpecTree(path string) (*Node, string, error) {
+	l := &specLoader{active: map[string]bool{}}
+	return l.load(path, Pos{})
+}
+
+type specLoader struct {
+	// chain and active hold the files being loaded, to detect cycles.
+	chain  []string
+	active map[string]bool
+}
+
+func (l *specLoader) load(path string, from Pos) (*Node, string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if l.active[abs] {
+		return nil, "", posErrorf(from, "spec cycle: %s -> %s", strings.Join(l.chain, " -> "), path)
+	}
+	l.active[abs] = true
+	l.chain = append(l.chain, path)
+	defer func() {
+		delete(l.active, abs)
+		l.chain = l.chain[:len(l.chain)-1]
+	}()
+
+	root, format, err := parseSpecFile(path)
+	if err != nil || root.Kind != MapNode {
+		return root, format, err
+	}
+
+	var parents []*Field
+	if f := root.field("extends"); f != nil {
+		if f.Value.Kind != ScalarNode {
+			return nil, "", posErrorf(f.Value.Pos, "extends: expected a path to a spec")
+		}
+		parents = append(parents, f)
+	}
+	if f := root.field("include"); f != nil {
+		items := listItems(f.Value)
+		for _, item := range items {
+			if item.Kind != ScalarNode {
+				return nil, "", posErrorf(item.Pos, "include: expected a path or a list of paths")
+			}
+		}
+		for _, item := range items {
+			parents = append(parents, &Field{Key: f.Key, KeyPos: f.KeyPos, Value: item})
+		}
+	}
+
+	var merged *Node
+	for _, f := range parents {
+		ref := f.Value.Value
+		if !filepath.IsAbs(ref) {
+			ref = filepath.Join(filepath.Dir(path), ref)
+		}
+		parent, _, err := l.load(ref, f.Value.Pos)
+		if err != nil {
+			return nil, "", err
+		}
+		if parent.Kind != MapNode {
+			return nil, "", posErrorf(f.Value.Pos, "%s: %s is not a map", f.Key, ref)
+		}
+		merged = mergeMaps(merged, parent)
+	}
+	own := &Node{Kind: MapNode, Pos: root.Pos}
+	for _, f := range root.Fields {
+		if f.Key != "extends" && f.Key != "include" {
+			own.Fields = append(own.Fields, f)
+		}
+	}
+	return mergeMaps(merged, own), format, nil
+}
+
+// mergeMaps merges two spec documents; neither is modified.
+func mergeMaps(base, overlay *Node) *Node {
+	if base == nil {
+		return clean(overlay)
+	}
+	return mergeMap(base, overlay, true)
+}
+
+// mergeNode merges overlay onto base: maps merge key by key, lists (or a
+// list and a single value) append, and anything else is replaced.
+func mergeNode(base, overlay *Node, byName bool) *Node {
+	switch {
+	case base == nil || base.Kind == NullNode || overlay.Kind == NullNode:
+		return clean(overlay)
+	case byName:
+		return mergeDependencies(base, overlay)
+	case base.Kind == MapNode && overlay.Kind == MapNode:
+		return mergeMap(base, overlay, false)
+	case base.Kind == MapNode || overlay.Kind == MapNode:
+		return clean(overlay)
+	case base.Kind == ListNode || overlay.Kind == ListNode:
+		out := &Node{Kind: ListNode, Pos: overlay.Pos}
+		out.Items = append(out.Items, listItems(base)...)
+		for _, item := range listItems(overlay) {
+			out.Items = append(out.Items, clean(item))
+		}
+		return out
+	default:
+		return overlay
+	}
+}
+
+// mergeMap merges the keys of overlay into base. A key written with a "!"
+// suffix, such as "exclude!", replaces the base value instead of merging
+// with it.
+func mergeMap(base, overlay *Node, top bool) *Node {
+	out := &Node{Kind: MapNode, Pos: base.Pos}
+	for _, f := range base.Fields {
+		copied := *f
+		out.Fields = append(out.Fields, &copied)
+	}
+	for _, f := range overlay.Fields {
+		key, replace := strings.CutSuffix(f.Key, "!")
+		existing := out.field(key)
+		switch {
+		case existing == nil:
+			out.Fields = append(out.Fields, &Field{Key: key, KeyPos: f.KeyPos, Value: clean(f.Value)})
+		case replace:
+			existing.KeyPos, existing.Value = f.KeyPos, clean(f.Value)
+		default:
+			existing.KeyPos, existing.Value = f.KeyPos, mergeNode(existing.Value, f.Value, top && mergeByName[key])
+		}
+	}
+	return out
+}
+
+// mergeDependencies merges dependency lists by package name: an entry for
+// a name that is already listed updates that entry, e.g. its "ver".
+func mergeDependencies(base, overlay *Node) *Node {
+	out := &Node{Kind: ListNode, Pos: overlay.Pos}
+	out.Items = append(out.Items, listItems(base)...)
+	for _, dep := range listItems(overlay) {
+		i := indexDependency(out.Items, dependencyName(dep))
+		if i < 0 {
+			out.Items = append(out.Items, clean(dep))
+			continue
+		}
+		out.Items[i] = mergeMap(dependencyMap(out.Items[i]), dependencyMap(dep), false)
+	}
+	return out
+}
+
+func indexDependency(deps []*Node, name string) int {
+	if name == "" {
+		return -1
+	}
+	for i, dep := range deps {
+		if dependencyName(dep) == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func dependencyName(n *Node) string {
+	if n.Kind == ScalarNode {
+		return parseDependency(n.Value).Name
+	}
+	if name := n.Get("name"); name != nil {
+		return name.Value
+	}
+	return ""
+}
+
+// dependencyMap turns the short form "name constraint" into a map, so that
+// it can be merged with the long form.
+func dependencyMap(n *Node) *Node {
+	if n.Kind != ScalarNode {
+		return n
+	}
+	dep := parseDependency(n.Value)
+	out := &Node{Kind: MapNode, Pos: n.Pos, Fields: []*Field{
+		{Key: "name", KeyPos: n.Pos, Value: &Node{Kind: ScalarNode, Pos: n.Pos, Value: dep.Name}},
+	}}
+	if dep.Version != "" {
+		out.Fields = append(out.Fields, &Field{Key: "ver", KeyPos: n.Pos, Value: &Node{Kind: ScalarNode, Pos: n.Pos, Value: dep.Version}})
+	}
+	return out
+}
+
+func listItems(n *Node) []*Node {
+	switch n.Kind {
+	case ListNode:
+		return n.Items
+	case NullNode:
+		return nil
+	default:
+		return []*Node{n}
+	}
+}
+
+// clean drops the "!" suffix from keys of a value that has nothing to be
+// merged with.
+func clean(n *Node) *Node {
+	switch n.Kind {
+	case MapNode:
+		out := &Node{Kind: MapNode, Pos: n.Pos}
+		for _, f := range n.Fields {
+			key, _ := strings.CutSuffix(f.Key, "!")
+			out.Fields = append(out.Fields, &Field{Key: key, KeyPos: f.KeyPos, Value: clean(f.Value)})
+		}
+		return out
+	case ListNode:
+		out := &Node{Kind: ListNode, Pos: n.Pos}
+		for _, item := range n.Items {
+			out.Items = append(out.Items, clean(item))
+		}
+		return out
+	default:
+		return n
+	}
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// AnyPlatform is the os or arch of a package that runs everywhere.
+const AnyPlatform = "any"
+
+// Platform is the os/arch pair a package is built for. An empty field
+// means the package does not depend on it.
+type Platform struct {
+	OS   string
+	Arch string
+}
+
+var (
+	knownOS = map[string]bool{
+		"aix": true, "android": true, "darwin": true, "dragonfly": true, "freebsd": true,
+		"illumos": true, "ios": true, "js": true, "linux": true, "netbsd": true,
+		"openbsd": true, "plan9": true, "solaris": true, "wasip1": true, "windows": true,
+	}
+	knownArch = map[string]bool{
+		"386": true, "amd64": true, "arm": true, "arm64": true, "loong64": true,
+		"mips": true, "mips64": true, "mips64le": true, "mipsle": true, "ppc64": true,
+		"ppc64le": true, "riscv64": true, "s390x": true, "wasm": true,
+	}
+)
+
+// HostPlatform returns the platform pm is running on.
+func HostPlatform() Platform {
+	return Platform{OS: runtime.GOOS, Arch: runtime.GOARCH}
+}
+
+// ParsePlatform parses "os/arch", "os" or "any". Names are the ones used by
+// GOOS and GOARCH.
+func ParsePlatform(s string) (Platform, error) {
+	osName, arch, _ := strings.Cut(s, "/")
+	p, err := NewPlatform(osName, arch)
+	if err != nil {
+		return Platform{}, fmt.Errorf("platform %q: %w", s, err)
+	}
+	return p, nil
+}
+
+// NewPlatform checks os and arch; "" and "any" both mean any.
+func NewPlatform(osName, arch string) (Platform, error) {
+	if osName == AnyPlatform {
+		osName = ""
+	}
+	if arch == AnyPlatform {
+		arch = ""
+	}
+	if osName != "" && !knownOS[osName] {
+		return Platform{}, fmt.Errorf("unknown os %q", osName)
+	}
+	if arch != "" && !knownArch[arch] {
+		return Platform{}, fmt.Errorf("unknown arch %q", arch)
+	}
+	return Platform{OS: osName, Arch: arch}, nil
+}
+
+// IsAny reports whether the platform matches every host.
+func (p Platform) IsAny() bool {
+	return p.OS == "" && p.Arch == ""
+}
+
+// Matches reports whether a package built for p can be installed on host.
+func (p Platform) Matches(host Platform) bool {
+	return (p.OS == "" || p.OS == host.OS) && (p.Arch == "" || p.Arch == host.Arch)
+}
+
+// Specificity counts the fields that are not "any"; a host prefers the most
+// specific of several matching variants.
+func (p Platform) Specificity() int {
+	n := 0
+	if p.OS != "" {
+		n++
+	}
+	if p.Arch != "" {
+		n++
+	}
+	return n
+}
+
+// Suffix is appended to the archive name: "-linux-amd64", "-linux-any", or
+// "" for a platform-independent package.
+func (p Platform) Suffix() string {
+	if p.IsAny() {
+		return ""
+	}
+	return "-" + orAny(p.OS) + "-" + orAny(p.Arch)
+}
+
+// ParsePlatformSuffix splits "name-version-os-arch" into "name-version" and
+// the platform. Names without a platform suffix are platform-independent.
+func ParsePlatformSuffix(base string) (string, Platform) {
+	parts := strings.Split(base, "-")
+	if len(parts) < 4 {
+		return base, Platform{}
+	}
+	osName, arch := parts[len(parts)-2], parts[len(parts)-1]
+	if (osName != AnyPlatform && !knownOS[osName]) || (arch != AnyPlatform && !knownArch[arch]) {
+		return base, Platform{}
+	}
+	p, _ := NewPlatform(osName, arch)
+	return strings.Join(parts[:len(parts)-2], "-"), p
+}
+
+func (p Platform) String() string {
+	if p.IsAny() {
+		return AnyPlatform
+	}
+	return orAny(p.OS) + "/" + orAny(p.Arch)
+}
+
+func orAny(s string) string {
+	if s == "" {
+		return AnyPlatform
+	}
+	return s
+}
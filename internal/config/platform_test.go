@@ -0,0 +1,98 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePlatform(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   Platform
+		suffix string
+		str    string
+		err    string
+	}{
+		{in: "linux/amd64", want: Platform{OS: "linux", Arch: "amd64"}, suffix: "-linux-amd64", str: "linux/amd64"},
+		{in: "darwin", want: Platform{OS: "darwin"}, suffix: "-darwin-any", str: "darwin/any"},
+		{in: "linux/any", want: Platform{OS: "linux"}, suffix: "-linux-any", str: "linux/any"},
+		{in: "any/arm64", want: Platform{Arch: "arm64"}, suffix: "-any-arm64", str: "any/arm64"},
+		{in: "any", want: Platform{}, suffix: "", str: "any"},
+		{in: "", want: Platform{}, suffix: "", str: "any"},
+		{in: "beos/amd64", err: `platform "beos/amd64": unknown os "beos"`},
+		{in: "linux/x86_64", err: `platform "linux/x86_64": unknown arch "x86_64"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParsePlatform(tt.in)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %+v, %v, want error %q", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if s := got.Suffix(); s != tt.suffix {
+				t.Errorf("suffix: got %q, want %q", s, tt.suffix)
+			}
+			if s := got.String(); s != tt.str {
+				t.Errorf("string: got %q, want %q", s, tt.str)
+			}
+		})
+	}
+}
+
+func TestPlatformMatches(t *testing.T) {
+	host := Platform{OS: "linux", Arch: "amd64"}
+	tests := []struct {
+		p           Platform
+		matches     bool
+		specificity int
+	}{
+		{Platform{OS: "linux", Arch: "amd64"}, true, 2},
+		{Platform{OS: "linux"}, true, 1},
+		{Platform{Arch: "amd64"}, true, 1},
+		{Platform{}, true, 0},
+		{Platform{OS: "linux", Arch: "arm64"}, false, 2},
+		{Platform{OS: "darwin"}, false, 1},
+		{Platform{Arch: "386"}, false, 1},
+	}
+	for _, tt := range tests {
+		if got := tt.p.Matches(host); got != tt.matches {
+			t.Errorf("%s matches %s: got %v, want %v", tt.p, host, got, tt.matches)
+		}
+		if got := tt.p.Specificity(); got != tt.specificity {
+			t.Errorf("%s specificity: got %d, want %d", tt.p, got, tt.specificity)
+		}
+	}
+}
+
+func TestParsePlatformSuffix(t *testing.T) {
+	tests := []struct {
+		base     string
+		rest     string
+		platform Platform
+	}{
+		{"app-1.0-linux-amd64", "app-1.0", Platform{OS: "linux", Arch: "amd64"}},
+		{"my-app-1.0-linux-any", "my-app-1.0", Platform{OS: "linux"}},
+		{"app-1.0-any-arm64", "app-1.0", Platform{Arch: "arm64"}},
+		// Without a platform suffix the whole name is kept.
+		{"app-1.0", "app-1.0", Platform{}},
+		{"my-app-1.0", "my-app-1.0", Platform{}},
+		// Names that merely end in two words are not platforms.
+		{"my-cool-app-1.0", "my-cool-app-1.0", Platform{}},
+		{"app-1.0-linux-x86_64", "app-1.0-linux-x86_64", Platform{}},
+		{"linux-amd64", "linux-amd64", Platform{}},
+	}
+	for _, tt := range tests {
+		rest, p := ParsePlatformSuffix(tt.base)
+		if rest != tt.rest || p != tt.platform {
+			t.Errorf("%s: got %q %+v, want %q %+v", tt.base, rest, p, tt.rest, tt.platform)
+		}
+	}
+}
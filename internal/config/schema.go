@@ -0,0 +1,226 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type scalarKind int
+
+const (
+	noScalar scalarKind = iota
+	scalarString
+	scalarBool
+	scalarInt
+)
+
+// shape describes what a spec value may look like: which scalar it takes,
+// whether it may be a list (and of what), and whether it may be a map (and
+// with which keys). Fields like "exclude" accept both a string and a list.
+type shape struct {
+	scalar scalarKind
+	items  *shape
+	object bool
+	fields map[string]*shape
+	// required lists the keys a map must have.
+	required []string
+	// extensions allows keys starting with "x-", e.g. to hold YAML anchors.
+	extensions bool
+	// order is the canonical order of the keys, used by pm fmt.
+	order []string
+}
+
+func (s *shape) expected() string {
+	var kinds []string
+	switch s.scalar {
+	case scalarString:
+		kinds = append(kinds, "a string")
+	case scalarBool:
+		kinds = append(kinds, "true or false")
+	case scalarInt:
+		kinds = append(kinds, "an integer")
+	}
+	if s.items != nil {
+		kinds = append(kinds, "a list")
+	}
+	if s.object {
+		kinds = append(kinds, "a map")
+	}
+	return strings.Join(kinds, " or ")
+}
+
+var (
+	stringShape     = &shape{scalar: scalarString}
+	boolShape       = &shape{scalar: scalarBool}
+	intShape        = &shape{scalar: scalarInt}
+	stringListShape = &shape{scalar: scalarString, items: stringShape}
+
+	dependencyFields = map[string]*shape{
+		"name":     stringShape,
+		"ver":      stringShape,
+		"optional": boolShape,
+		"with":     &shape{items: stringShape},
+	}
+	dependencyOrder      = []string{"name", "ver", "optional", "with"}
+	dependencyShape      = &shape{scalar: scalarString, object: true, fields: dependencyFields, required: []string{"name"}, order: dependencyOrder}
+	dependencyArrayShape = &shape{items: dependencyShape}
+	// DependencyList also takes a single dependency.
+	dependencyListShape = &shape{scalar: scalarString, items: dependencyShape, object: true, fields: dependencyFields, required: []string{"name"}, order: dependencyOrder}
+
+	targetShape = &shape{scalar: scalarString, object: true, fields: map[string]*shape{
+		"path":         stringListShape,
+		"exclude":      stringListShape,
+		"dest":         stringShape,
+		"strip_prefix": stringShape,
+		// An unquoted YAML 0755 must not become the integer 755.
+		"mode":        stringShape,
+		"dotfiles":    boolShape,
+		"ignore_case": boolShape,
+		"required":    boolShape,
+		"min_files":   intShape,
+	}, required: []string{"path"}, order: []string{
+		"path", "exclude", "dest", "strip_prefix", "mode", "dotfiles", "ignore_case", "required", "min_files",
+	}}
+	targetListShape = &shape{items: targetShape}
+
+	scriptsShape = &shape{object: true, fields: map[string]*shape{
+		HookPreInstall:  stringShape,
+		HookPostInstall: stringShape,
+		HookPreRemove:   stringShape,
+		HookPostRemove:  stringShape,
+	}, order: []string{HookPreInstall, HookPostInstall, HookPreRemove, HookPostRemove}}
+
+	// "$schema" lets editors find the schema printed by pm schema.
+	packageShape = &shape{object: true, extensions: true, required: []string{"name", "ver", "targets"}, fields: map[string]*shape{
+		"$schema":         stringShape,
+		"extends":         stringShape,
+		"include":         stringListShape,
+		"name":            stringShape,
+		"ver":             stringShape,
+		"targets":         targetListShape,
+		"packets":         dependencyArrayShape,
+		"format":          stringShape,
+		"compression":     stringShape,
+		"follow_symlinks": boolShape,
+		"exclude":         stringListShape,
+		"dotfiles":        boolShape,
+		"ignore_case":     boolShape,
+		"scripts":         scriptsShape,
+		"description":     stringShape,
+		"license":         stringShape,
+		"maintainers":     stringListShape,
+		"homepage":        stringShape,
+		"tags":            stringListShape,
+		"readme":          stringShape,
+		"os":              stringShape,
+		"arch":            stringShape,
+		"conflicts":       dependencyListShape,
+		"provides":        dependencyListShape,
+		"replaces":        dependencyListShape,
+	}, order: []string{
+		"$schema", "extends", "include",
+		"name", "ver", "description", "license", "maintainers", "homepage", "tags", "readme", "os", "arch",
+		"format", "compression", "follow_symlinks", "dotfiles", "ignore_case", "exclude", "targets", "scripts",
+		"packets", "conflicts", "provides", "replaces",
+	}}
+
+	updateShape = &shape{object: true, extensions: true, required: []string{"packages"}, fields: map[string]*shape{
+		"$schema":  stringShape,
+		"extends":  stringShape,
+		"include":  stringListShape,
+		"packages": dependencyArrayShape,
+	}, order: []string{"$schema", "extends", "include", "packages"}}
+
+	workspaceShape = &shape{object: true, extensions: true, required: []string{"members"}, fields: map[string]*shape{
+		"$schema": stringShape,
+		"members": stringListShape,
+		"defaults": {object: true, fields: map[string]*shape{
+			"targets":     targetListShape,
+			"exclude":     stringListShape,
+			"packets":     dependencyArrayShape,
+			"format":      stringShape,
+			"compression": stringShape,
+		}, order: []string{"format", "compression", "exclude", "targets", "packets"}},
+	}, order: []string{"$schema", "members", "defaults"}}
+)
+
+// Spec kinds, as detected by Validate and accepted by JSONSchema.
+const (
+	SpecPackage   = "package"
+	SpecUpdate    = "update"
+	SpecWorkspace = "workspace"
+)
+
+var specShapes = map[string]*shape{
+	SpecPackage:   packageShape,
+	SpecUpdate:    updateShape,
+	SpecWorkspace: workspaceShape,
+}
+
+// specKind tells a workspace, which has members, from an update spec, which
+// has packages but no name or targets, and from a package spec.
+func specKind(root *Node) string {
+	switch {
+	case root.Get("members") != nil:
+		return SpecWorkspace
+	case root.Get("packages") != nil && root.Get("name") == nil && root.Get("targets") == nil:
+		return SpecUpdate
+	default:
+		return SpecPackage
+	}
+}
+
+// JSONSchema returns the JSON Schema of a spec kind, for editors to check
+// and complete specs with.
+func JSONSchema(kind string) ([]byte, error) {
+	s, ok := specShapes[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown spec kind %q, expected %s, %s or %s", kind, SpecPackage, SpecUpdate, SpecWorkspace)
+	}
+	doc := s.jsonSchema()
+	doc["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	doc["title"] = "pm " + kind + " spec"
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+func (s *shape) jsonSchema() map[string]any {
+	out := map[string]any{}
+	var types []string
+	switch s.scalar {
+	case scalarString:
+		types = append(types, "string")
+	case scalarBool:
+		types = append(types, "boolean")
+	case scalarInt:
+		types = append(types, "integer")
+	}
+	if s.items != nil {
+		types = append(types, "array")
+		out["items"] = s.items.jsonSchema()
+	}
+	if s.object {
+		types = append(types, "object")
+		props := map[string]any{}
+		for key, field := range s.fields {
+			props[key] = field.jsonSchema()
+		}
+		out["properties"] = props
+		out["additionalProperties"] = false
+		if s.extensions {
+			// "key!" replaces what extends and include set for key.
+			out["patternProperties"] = map[string]any{"^x-": map[string]any{}, "!$": map[string]any{}}
+		}
+		// A spec that extends or includes another inherits fields from
+		// it, so what it must have is only checked after merging.
+		if len(s.required) > 0 && s.fields["extends"] == nil {
+			out["required"] = s.required
+		}
+	}
+	if len(types) == 1 {
+		out["type"] = types[0]
+	} else {
+		out["type"] = types
+	}
+	return out
+}
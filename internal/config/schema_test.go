@@ -0,0 +1,80 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Specs with extends and include may inherit their required fields, so
+// the published schema must not require them; the workspace schema does.
+func TestJSONSchemaRequired(t *testing.T) {
+	tests := []struct {
+		kind string
+		want []any
+	}{
+		{SpecPackage, nil},
+		{SpecUpdate, nil},
+		{SpecWorkspace, []any{"members"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			data, err := JSONSchema(tt.kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var doc map[string]any
+			if err := json.Unmarshal(data, &doc); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := doc["required"].([]any)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("required: got %v, want %v", got, tt.want)
+			}
+			// Nested objects, such as a target's path, are not inherited
+			// piecewise and stay required.
+			if tt.kind == SpecPackage {
+				target := doc["properties"].(map[string]any)["targets"].(map[string]any)["items"].(map[string]any)
+				if got := target["required"]; !reflect.DeepEqual(got, []any{"path"}) {
+					t.Errorf("targets[].required: got %v, want [path]", got)
+				}
+			}
+		})
+	}
+}
+
+// A child spec that inherits name, ver and targets validates cleanly, while
+// a spec missing them after merging is reported.
+func TestValidateInheritedRequired(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"base.yaml":  "name: app\nver: \"1.0\"\ntargets: [bin/*]\n",
+		"child.yaml": "extends: base.yaml\ndescription: child\n",
+		"lone.yaml":  "description: lone\nx-a: 1\ntargets: [bin/*]\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := Validate(filepath.Join(dir, "child.yaml"), ValidateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Problems) != 0 {
+		t.Errorf("child: unexpected problems %v", res.Problems)
+	}
+	res, err = Validate(filepath.Join(dir, "lone.yaml"), ValidateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []string
+	for _, p := range res.Problems {
+		msgs = append(msgs, p.Message)
+	}
+	want := []string{`missing required field "name"`, `missing required field "ver"`}
+	if !reflect.DeepEqual(msgs, want) {
+		t.Errorf("lone: got %q, want %q", msgs, want)
+	}
+}
@@ -11,27 +11,165 @@ import (
 )
 
 type PackageSpec struct {
-	Name     string           `json:"name" yaml:"name"`
-	Version  string           `json:"ver" yaml:"ver"`
-	Targets  []TargetSpec     `json:"targets" yaml:"targets"`
-	Packages []DependencySpec `json:"packets" yaml:"packets"`
+	Name           string           `json:"name" yaml:"name"`
+	Version        string           `json:"ver" yaml:"ver"`
+	Targets        []TargetSpec     `json:"targets" yaml:"targets"`
+	Packages       []DependencySpec `json:"packets" yaml:"packets"`
+	Format         string           `json:"format" yaml:"format"`
+	Compression    string           `json:"compression" yaml:"compression"`
+	FollowSymlinks bool             `json:"follow_symlinks" yaml:"follow_symlinks"`
+	Exclude        StringList       `json:"exclude" yaml:"exclude"`
+	Dotfiles       bool             `json:"dotfiles" yaml:"dotfiles"`
+	IgnoreCase     bool             `json:"ignore_case" yaml:"ignore_case"`
+	Scripts        ScriptsSpec      `json:"scripts" yaml:"scripts"`
+	Description    string           `json:"description" yaml:"description"`
+	License        string           `json:"license" yaml:"license"`
+	Maintainers    StringList       `json:"maintainers" yaml:"maintainers"`
+	Homepage       string           `json:"homepage" yaml:"homepage"`
+	Tags           StringList       `json:"tags" yaml:"tags"`
+	Readme         string           `json:"readme" yaml:"readme"`
+	OS             string           `json:"os" yaml:"os"`
+	Arch           string           `json:"arch" yaml:"arch"`
+	Conflicts      DependencyList   `json:"conflicts" yaml:"conflicts"`
+	Provides       DependencyList   `json:"provides" yaml:"provides"`
+	Replaces       DependencyList   `json:"replaces" yaml:"replaces"`
+}
+
+// Platform returns the os/arch the package is built for.
+func (s *PackageSpec) Platform() (Platform, error) {
+	return NewPlatform(s.OS, s.Arch)
+}
+
+// ScriptsSpec names script files, relative to the working directory, that
+// are shipped in the package and run by update and remove.
+type ScriptsSpec struct {
+	PreInstall  string `json:"pre_install" yaml:"pre_install"`
+	PostInstall string `json:"post_install" yaml:"post_install"`
+	PreRemove   string `json:"pre_remove" yaml:"pre_remove"`
+	PostRemove  string `json:"post_remove" yaml:"post_remove"`
+}
+
+const (
+	HookPreInstall  = "pre_install"
+	HookPostInstall = "post_install"
+	HookPreRemove   = "pre_remove"
+	HookPostRemove  = "post_remove"
+)
+
+// Hooks returns the configured scripts keyed by hook name.
+func (s ScriptsSpec) Hooks() map[string]string {
+	hooks := map[string]string{}
+	for name, script := range map[string]string{
+		HookPreInstall:  s.PreInstall,
+		HookPostInstall: s.PostInstall,
+		HookPreRemove:   s.PreRemove,
+		HookPostRemove:  s.PostRemove,
+	} {
+		if script != "" {
+			hooks[name] = script
+		}
+	}
+	return hooks
+}
+
+type StringList []string
+
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = StringList{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("expected string or array of strings")
+	}
+	*l = list
+	return nil
 }
 
 type TargetSpec struct {
-	Pattern string
-	Exclude []string
+	Patterns    []string
+	Exclude     []string
+	Dest        string
+	StripPrefix string
+	Mode        os.FileMode
+	Dotfiles    bool
+	IgnoreCase  bool
+	Required    bool
+	MinFiles    int
+}
+
+func (t TargetSpec) String() string {
+	if len(t.Patterns) == 1 {
+		return t.Patterns[0]
+	}
+	return "[" + strings.Join(t.Patterns, ", ") + "]"
 }
 
 type DependencySpec struct {
 	Name    string `json:"name" yaml:"name"`
 	Version string `json:"ver" yaml:"ver"`
+	// Optional dependencies are installed only when the dependent package
+	// asks for them with With, or update runs with --with-optional.
+	Optional bool     `json:"optional,omitempty" yaml:"optional"`
+	With     []string `json:"with,omitempty" yaml:"with"`
+}
+
+// UnmarshalJSON also accepts the short form "name" or "name constraint",
+// e.g. "liba >=1.2".
+func (d *DependencySpec) UnmarshalJSON(data []byte) error {
+	var short string
+	if err := json.Unmarshal(data, &short); err == nil {
+		*d = parseDependency(short)
+		return nil
+	}
+	type plain DependencySpec
+	return json.Unmarshal(data, (*plain)(d))
+}
+
+func parseDependency(short string) DependencySpec {
+	name, constraint, _ := strings.Cut(strings.TrimSpace(short), " ")
+	if i := strings.IndexAny(name, "<>="); i > 0 {
+		name, constraint = name[:i], name[i:]+constraint
+	}
+	return DependencySpec{Name: name, Version: strings.TrimSpace(constraint)}
+}
+
+// DependencyList is a list of dependencies that may also be written as a
+// single one.
+type DependencyList []DependencySpec
+
+func (l *DependencyList) UnmarshalJSON(data []byte) error {
+	var list []DependencySpec
+	if err := json.Unmarshal(data, &list); err == nil {
+		*l = list
+		return nil
+	}
+	var single DependencySpec
+	if err := json.Unmarshal(data, &single); err != nil {
+		return errors.New("expected dependency or array of dependencies")
+	}
+	*l = DependencyList{single}
+	return nil
+}
+
+func (d DependencySpec) String() string {
+	s := d.Name
+	if d.Version != "" {
+		s += " " + d.Version
+	}
+	if d.Optional {
+		s += " (optional)"
+	}
+	return s
 }
 
 func (t *TargetSpec) UnmarshalJSON(data []byte) error {
 	var asString string
 	if err := json.Unmarshal(data, &asString); err == nil {
-		t.Pattern = asString
-		return nil
+		t.Patterns = []string{asString}
+		return t.checkPatterns()
 	}
 
 	var raw map[string]any
@@ -39,329 +177,293 @@ func (t *TargetSpec) UnmarshalJSON(data []byte) error {
 		return fmt.Errorf("target must be string or object: %w", err)
 	}
 
-	pathVal, ok := raw["path"].(string)
-	if !ok || pathVal == "" {
+	patterns, err := stringOrList(raw["path"], "path")
+	if err != nil {
+		return err
+	}
+	if len(patterns) == 0 {
 		return errors.New("target object must have non-empty path")
 	}
-	t.Pattern = pathVal
+	t.Patterns = patterns
+	if err := t.checkPatterns(); err != nil {
+		return err
+	}
 
 	if ex, ok := raw["exclude"]; ok {
-		switch v := ex.(type) {
-		case string:
-			t.Exclude = []string{v}
-		case []any:
-			for _, item := range v {
-				s, ok := item.(string)
-				if !ok {
-					return errors.New("exclude entries must be strings")
-				}
-				t.Exclude = append(t.Exclude, s)
+		if t.Exclude, err = stringOrList(ex, "exclude"); err != nil {
+			return err
+		}
+	}
+
+	for key, field := range map[string]*string{"dest": &t.Dest, "strip_prefix": &t.StripPrefix} {
+		if v, ok := raw[key]; ok {
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("%s must be a string", key)
 			}
-		default:
-			return errors.New("exclude must be string or array of strings")
+			*field = s
 		}
 	}
-	return nil
-}
 
-func LoadPackageSpec(path string) (*PackageSpec, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
+	for key, field := range map[string]*bool{"dotfiles": &t.Dotfiles, "ignore_case": &t.IgnoreCase, "required": &t.Required} {
+		if v, ok := raw[key]; ok {
+			b, ok := v.(bool)
+			if !ok {
+				return fmt.Errorf("%s must be a boolean", key)
+			}
+			*field = b
+		}
 	}
 
-	spec := &PackageSpec{}
-	switch ext := filepath.Ext(path); ext {
-	case ".yaml", ".yml":
-		jsonData, err := parseYAMLToJSON(data)
+	if v, ok := raw["min_files"]; ok {
+		n, ok := v.(float64)
+		if !ok || n < 0 || n != float64(int(n)) {
+			return errors.New("min_files must be a non-negative integer")
+		}
+		t.MinFiles = int(n)
+	}
+
+	if m, ok := raw["mode"]; ok {
+		mode, err := parseMode(m)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse YAML: %w", err)
+			return err
 		}
-		if err := json.Unmarshal(jsonData, spec); err != nil {
-			return nil, err
+		t.Mode = mode
+	}
+	return nil
+}
+
+// checkPatterns requires at least one pattern that is not a "!" negation,
+// otherwise the target could never match anything.
+func (t *TargetSpec) checkPatterns() error {
+	for _, p := range t.Patterns {
+		if p == "" {
+			return errors.New("target patterns must not be empty")
 		}
-	default:
-		if err := json.Unmarshal(data, spec); err != nil {
-			if jsonData, yamlErr := parseYAMLToJSON(data); yamlErr == nil {
-				if err := json.Unmarshal(jsonData, spec); err != nil {
-					return nil, err
-				}
-			} else {
-				return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	for _, p := range t.Patterns {
+		if !strings.HasPrefix(p, "!") {
+			return nil
+		}
+	}
+	return fmt.Errorf("target %s has only negated patterns", t)
+}
+
+func stringOrList(v any, key string) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		return []string{v}, nil
+	case []any:
+		var out []string
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s entries must be strings", key)
 			}
+			out = append(out, s)
 		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("%s must be string or array of strings", key)
 	}
+}
 
-	if spec.Name == "" {
-		return nil, errors.New("package spec missing name")
+// parseMode accepts "0755"/"755" or a number whose digits are read as octal,
+// which is what YAML produces for an unquoted 0755.
+func parseMode(v any) (os.FileMode, error) {
+	var digits string
+	switch m := v.(type) {
+	case string:
+		digits = strings.TrimPrefix(strings.TrimPrefix(m, "0o"), "0")
+	case float64:
+		if m != float64(int64(m)) {
+			return 0, fmt.Errorf("invalid mode %v", m)
+		}
+		digits = strconv.FormatInt(int64(m), 10)
+	default:
+		return 0, errors.New("mode must be an octal string like \"0755\"")
 	}
-	if spec.Version == "" {
-		return nil, errors.New("package spec missing version")
+	if digits == "" {
+		digits = "0"
 	}
-	if len(spec.Targets) == 0 {
-		return nil, errors.New("package spec must define at least one target")
+	mode, err := strconv.ParseUint(digits, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid mode %v", v)
 	}
-	return spec, nil
+	return os.FileMode(mode), nil
 }
 
-type UpdateSpec struct {
-	Packages []DependencySpec `json:"packages" yaml:"packages"`
+func LoadPackageSpec(path string) (*PackageSpec, error) {
+	return loadPackageSpec(path, nil)
 }
 
-func LoadUpdateSpec(path string) (*UpdateSpec, error) {
-	data, err := os.ReadFile(path)
+func loadPackageSpec(path string, defaults *SpecDefaults) (*PackageSpec, error) {
+	spec := &PackageSpec{}
+	root, err := decodeSpecFile(path, packageShape, spec)
 	if err != nil {
 		return nil, err
 	}
-
-	spec := &UpdateSpec{}
-	switch ext := filepath.Ext(path); ext {
-	case ".yaml", ".yml":
-		jsonData, err := parseYAMLToJSON(data)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse YAML: %w", err)
-		}
-		if err := json.Unmarshal(jsonData, spec); err != nil {
-			return nil, err
-		}
-	default:
-		if err := json.Unmarshal(data, spec); err != nil {
-			if jsonData, yamlErr := parseYAMLToJSON(data); yamlErr == nil {
-				if err := json.Unmarshal(jsonData, spec); err != nil {
-					return nil, err
-				}
-			} else {
-				return nil, fmt.Errorf("failed to parse JSON: %w", err)
-			}
-		}
+	if defaults != nil {
+		defaults.apply(spec)
 	}
 
-	if len(spec.Packages) == 0 {
-		return nil, errors.New("update spec must declare packages")
+	if err := spec.interpolate(newInterpolator(path, root)); err != nil {
+		return nil, err
 	}
-	return spec, nil
-}
-
-type yamlParser struct {
-	lines []string
-	pos   int
-}
 
-func parseYAMLToJSON(data []byte) ([]byte, error) {
-	parser := &yamlParser{lines: preprocessYAMLLines(string(data))}
-	value, err := parser.parseBlock(0)
-	if err != nil {
+	if spec.Name == "" {
+		return nil, errors.New("package spec missing name")
+	}
+	if spec.Version == "" {
+		return nil, errors.New("package spec missing version")
+	}
+	if len(spec.Targets) == 0 {
+		return nil, errors.New("package spec must define at least one target")
+	}
+	if _, err := spec.Platform(); err != nil {
 		return nil, err
 	}
-	if value == nil {
-		return nil, errors.New("empty YAML content")
+	if err := spec.checkRelations(); err != nil {
+		return nil, err
 	}
-	return json.Marshal(value)
+	return spec, nil
 }
 
-func preprocessYAMLLines(input string) []string {
-	rawLines := strings.Split(input, "\n")
-	var lines []string
-	for _, line := range rawLines {
-		trimmed := strings.TrimSpace(line)
-		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
-			continue
-		}
-		content := line
-		if idx := strings.Index(line, "#"); idx != -1 {
-			before := line[:idx]
-			if strings.TrimSpace(before) == "" {
-				continue
+// checkRelations catches dependency lists that name no package or that
+// relate the package to itself.
+func (spec *PackageSpec) checkRelations() error {
+	for _, list := range []struct {
+		field string
+		deps  []DependencySpec
+	}{
+		{"packets", spec.Packages},
+		{"conflicts", spec.Conflicts},
+		{"provides", spec.Provides},
+		{"replaces", spec.Replaces},
+	} {
+		for i, dep := range list.deps {
+			if dep.Name == "" {
+				return fmt.Errorf("%s[%d]: missing name", list.field, i)
+			}
+			if dep.Name == spec.Name {
+				return fmt.Errorf("%s[%d]: package %s cannot name itself", list.field, i, spec.Name)
+			}
+			if list.field != "packets" && (dep.Optional || len(dep.With) > 0) {
+				return fmt.Errorf("%s[%d]: optional and with only apply to packets", list.field, i)
 			}
-			content = before
 		}
-		if strings.TrimSpace(content) == "" {
-			continue
+	}
+	for i, dep := range spec.Provides {
+		// "jdk =17" and "jdk 17" mean the same.
+		version := strings.TrimSpace(strings.TrimLeft(dep.Version, "="))
+		if strings.ContainsAny(version, "<>=") {
+			return fmt.Errorf("provides[%d]: %s must be an exact version, not a constraint", i, dep.Version)
 		}
-		lines = append(lines, content)
+		spec.Provides[i].Version = version
 	}
-	return lines
+	return nil
 }
 
-func (p *yamlParser) parseBlock(indent int) (any, error) {
-	for p.pos < len(p.lines) {
-		lineIndent, trimmed := p.currentLine()
-		if trimmed == "" {
-			p.pos++
-			continue
-		}
-		if lineIndent < indent {
-			return nil, nil
-		}
-		if strings.HasPrefix(trimmed, "- ") {
-			return p.parseList(indent)
-		}
-		return p.parseMap(indent)
+// decodeSpecFile reads a JSON, YAML or TOML spec into v, after resolving
+// its extends and include directives, and returns the merged tree. The spec
+// is checked against schema, which also decides which scalars are kept as
+// strings; a nil schema converts scalars by their source type.
+func decodeSpecFile(path string, schema *shape, v any) (*Node, error) {
+	root, _, err := loadSpecTree(path)
+	if err != nil {
+		return nil, err
 	}
-	return nil, nil
+	return root, decodeNode(root, schema, v)
 }
 
-func (p *yamlParser) parseList(indent int) ([]any, error) {
-	var result []any
-	for p.pos < len(p.lines) {
-		lineIndent, trimmed := p.currentLine()
-		if lineIndent < indent {
-			break
-		}
-		if lineIndent > indent {
-			return nil, fmt.Errorf("invalid indentation in list")
-		}
-		if !strings.HasPrefix(strings.TrimSpace(trimmed), "- ") {
-			break
-		}
-		trimmed = strings.TrimSpace(trimmed)[2:]
-		trimmed = strings.TrimSpace(trimmed)
-		p.pos++
-
-		if trimmed == "" {
-			val, err := p.parseBlock(indent + 2)
-			if err != nil {
-				return nil, err
-			}
-			result = append(result, val)
-			continue
-		}
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+	formatTOML = "toml"
+)
 
-		if strings.Contains(trimmed, ":") {
-			key, valStr := splitKeyValue(trimmed)
-			entry, err := p.parseInlineMap(key, valStr, indent+2)
-			if err != nil {
-				return nil, err
-			}
-			result = append(result, entry)
-			continue
+// sniffSpec parses a spec that is not JSON as TOML or YAML, whichever
+// yields a map, and returns it with its format.
+func sniffSpec(path string, data []byte) (*Node, string) {
+	for _, f := range []struct {
+		format string
+		parse  func(string, []byte) (*Node, error)
+	}{
+		{formatTOML, parseTOML},
+		{formatYAML, parseYAML},
+	} {
+		if root, err := f.parse(path, data); err == nil && root.Kind == MapNode {
+			return root, f.format
 		}
-
-		result = append(result, parseScalar(trimmed))
 	}
-	return result, nil
+	return nil, ""
 }
 
-func (p *yamlParser) parseInlineMap(key, valStr string, indent int) (map[string]any, error) {
-	result := map[string]any{}
-	if valStr == "" {
-		val, err := p.parseBlock(indent + 2)
-		if err != nil {
-			return nil, err
-		}
-		result[key] = val
-	} else {
-		result[key] = parseScalar(valStr)
+// parseSpecFile parses a spec of any format into a Node tree, picking the
+// format by extension. Other files are tried as JSON first and, if that is
+// not valid JSON, as TOML and then as YAML.
+func parseSpecFile(path string) (*Node, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
 	}
-
-	for p.pos < len(p.lines) {
-		lineIndent, trimmed := p.currentLine()
-		if lineIndent < indent {
-			break
-		}
-		if lineIndent > indent {
-			return nil, fmt.Errorf("invalid indentation in map entry")
-		}
-		trimmed = strings.TrimSpace(trimmed)
-		if strings.HasPrefix(trimmed, "- ") {
-			break
-		}
-		if trimmed == "" {
-			p.pos++
-			continue
-		}
-		if !strings.Contains(trimmed, ":") {
-			break
-		}
-		k, v := splitKeyValue(trimmed)
-		p.pos++
-		if v == "" {
-			val, err := p.parseBlock(indent + 2)
-			if err != nil {
-				return nil, err
-			}
-			result[k] = val
-		} else {
-			result[k] = parseScalar(v)
-		}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		root, err := parseYAML(path, data)
+		return root, formatYAML, err
+	case ".toml":
+		root, err := parseTOML(path, data)
+		return root, formatTOML, err
 	}
-	return result, nil
-}
-
-func (p *yamlParser) parseMap(indent int) (map[string]any, error) {
-	result := map[string]any{}
-	for p.pos < len(p.lines) {
-		lineIndent, trimmed := p.currentLine()
-		if lineIndent < indent {
-			break
-		}
-		if lineIndent > indent {
-			return nil, fmt.Errorf("invalid indentation in map")
-		}
-		trimmed = strings.TrimSpace(trimmed)
-		if trimmed == "" {
-			p.pos++
-			continue
-		}
-		if strings.HasPrefix(trimmed, "- ") {
-			break
-		}
-		if !strings.Contains(trimmed, ":") {
-			return nil, fmt.Errorf("invalid mapping entry: %s", trimmed)
-		}
-		key, valStr := splitKeyValue(trimmed)
-		p.pos++
-		if valStr == "" {
-			val, err := p.parseBlock(indent + 2)
-			if err != nil {
-				return nil, err
-			}
-			result[key] = val
-		} else {
-			result[key] = parseScalar(valStr)
+	root, err := parseJSON(path, data)
+	if err != nil {
+		if root, format := sniffSpec(path, data); root != nil {
+			return root, format, nil
 		}
+		return nil, "", err
 	}
-	return result, nil
+	return root, formatJSON, nil
 }
 
-func (p *yamlParser) currentLine() (int, string) {
-	line := p.lines[p.pos]
-	indent := 0
-	for indent < len(line) && line[indent] == ' ' {
-		indent++
+// decodeNode checks a parsed spec against schema and decodes it into v
+// through its JSON form, so all formats share one set of decoders.
+func decodeNode(root *Node, schema *shape, v any) error {
+	if root.Kind != MapNode {
+		return fmt.Errorf("%s: spec must be a map", root.Pos)
+	}
+	plain, err := root.decode(schema, "")
+	if err != nil {
+		return err
+	}
+	jsonData, err := json.Marshal(plain)
+	if err != nil {
+		return err
 	}
-	return indent, line[indent:]
+	return json.Unmarshal(jsonData, v)
 }
 
-func splitKeyValue(input string) (string, string) {
-	parts := strings.SplitN(input, ":", 2)
-	key := strings.TrimSpace(parts[0])
-	value := ""
-	if len(parts) > 1 {
-		value = strings.TrimSpace(parts[1])
-	}
-	return key, value
+type UpdateSpec struct {
+	Packages []DependencySpec `json:"packages" yaml:"packages"`
 }
 
-func parseScalar(input string) any {
-	input = strings.TrimSpace(input)
-	if input == "" {
-		return ""
-	}
-	if strings.HasPrefix(input, "\"") && strings.HasSuffix(input, "\"") {
-		return strings.Trim(input, "\"")
-	}
-	if strings.HasPrefix(input, "'") && strings.HasSuffix(input, "'") {
-		return strings.Trim(input, "'")
-	}
-	if input == "true" || input == "false" {
-		return input == "true"
+func LoadUpdateSpec(path string) (*UpdateSpec, error) {
+	spec := &UpdateSpec{}
+	root, err := decodeSpecFile(path, updateShape, spec)
+	if err != nil {
+		return nil, err
 	}
-	if i, err := strconv.Atoi(input); err == nil {
-		return i
+
+	if err := spec.interpolate(newInterpolator(path, root)); err != nil {
+		return nil, err
 	}
-	if f, err := strconv.ParseFloat(input, 64); err == nil {
-		return f
+
+	if len(spec.Packages) == 0 {
+		return nil, errors.New("update spec must declare packages")
 	}
-	return input
+	return spec, nil
 }
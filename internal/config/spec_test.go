@@ -0,0 +1,66 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTargetRequiredAndMinFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want TargetSpec
+		err  string
+	}{
+		{name: "required", src: `{"path": "bin/*", "required": true}`, want: TargetSpec{Patterns: []string{"bin/*"}, Required: true}},
+		{name: "not required", src: `{"path": "bin/*", "required": false}`, want: TargetSpec{Patterns: []string{"bin/*"}}},
+		{name: "min_files", src: `{"path": "bin/*", "min_files": 3}`, want: TargetSpec{Patterns: []string{"bin/*"}, MinFiles: 3}},
+		{name: "min_files zero", src: `{"path": "bin/*", "min_files": 0}`, want: TargetSpec{Patterns: []string{"bin/*"}}},
+		{name: "both", src: `{"path": "bin/*", "required": true, "min_files": 2}`, want: TargetSpec{Patterns: []string{"bin/*"}, Required: true, MinFiles: 2}},
+		{name: "required as string", src: `{"path": "bin/*", "required": "yes"}`, err: "required must be a boolean"},
+		{name: "min_files fraction", src: `{"path": "bin/*", "min_files": 1.5}`, err: "min_files must be a non-negative integer"},
+		{name: "min_files negative", src: `{"path": "bin/*", "min_files": -1}`, err: "min_files must be a non-negative integer"},
+		{name: "min_files string", src: `{"path": "bin/*", "min_files": "2"}`, err: "min_files must be a non-negative integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got TargetSpec
+			err := json.Unmarshal([]byte(tt.src), &got)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// In a spec file the same mistakes are reported with their position.
+func TestLoadPackageSpecMinFilesErrors(t *testing.T) {
+	tests := []struct {
+		value string
+		err   string
+	}{
+		{"two", `pm.yaml:5:16: targets[0].min_files: expected an integer, got "two"`},
+		{"1.5", `pm.yaml:5:16: targets[0].min_files: expected an integer, got "1.5"`},
+		{"-1", "min_files must be a non-negative integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			path := writeSpec(t, "pm.yaml", "name: app\nver: \"1\"\ntargets:\n  - path: bin/*\n    min_files: "+tt.value+"\n")
+			_, err := LoadPackageSpec(path)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
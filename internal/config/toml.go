@@ -0,0 +1,210 @@
+package config
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+)
+
+// parseTOML parses a TOML document into a Node tree. The tree is built from
+// the parser's AST, which keeps positions, and reports redefined keys and
+// tables itself; the document is then decoded by go-toml to enforce the
+// remaining TOML rules.
+func parseTOML(path string, data []byte) (*Node, error) {
+	b := &tomlBuilder{
+		path:    path,
+		root:    &Node{Kind: MapNode, Pos: Pos{File: path, Line: 1, Column: 1}},
+		headers: map[*Node]Pos{},
+		values:  map[*Node]bool{},
+		arrays:  map[*Node]bool{},
+	}
+	b.p.Reset(data)
+	current := b.root
+	for b.p.NextExpression() {
+		var err error
+		expr := b.p.Expression()
+		switch expr.Kind {
+		case unstable.KeyValue:
+			err = b.keyValue(current, expr)
+		case unstable.Table:
+			current, err = b.table(expr.Key(), false)
+		case unstable.ArrayTable:
+			current, err = b.table(expr.Key(), true)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := b.p.Error(); err != nil {
+		var pe *unstable.ParserError
+		if errors.As(err, &pe) {
+			start := b.p.Shape(b.p.Range(pe.Highlight)).Start
+			return nil, posErrorf(Pos{File: path, Line: start.Line, Column: start.Column}, "%s", pe.Message)
+		}
+		return nil, posErrorf(Pos{File: path}, "%v", err)
+	}
+
+	var check map[string]any
+	if err := toml.Unmarshal(data, &check); err != nil {
+		var de *toml.DecodeError
+		if errors.As(err, &de) {
+			line, col := de.Position()
+			return nil, posErrorf(Pos{File: path, Line: line, Column: col}, "%s", strings.TrimPrefix(de.Error(), "toml: "))
+		}
+		return nil, posErrorf(Pos{File: path}, "%s", strings.TrimPrefix(err.Error(), "toml: "))
+	}
+	return b.root, nil
+}
+
+type tomlBuilder struct {
+	path string
+	p    unstable.Parser
+	root *Node
+	// headers maps tables opened by a [header] to its position, values
+	// holds maps and lists written as values, which cannot be extended,
+	// and arrays the lists made by [[header]].
+	headers map[*Node]Pos
+	values  map[*Node]bool
+	arrays  map[*Node]bool
+}
+
+func (b *tomlBuilder) pos(n *unstable.Node) Pos {
+	r := n.Raw
+	if r.Length == 0 && len(n.Data) > 0 {
+		// Booleans and dates only reference their text through Data.
+		r = b.p.Range(n.Data)
+	}
+	start := b.p.Shape(r).Start
+	return Pos{File: b.path, Line: start.Line, Column: start.Column}
+}
+
+func keyParts(it unstable.Iterator) []*unstable.Node {
+	var keys []*unstable.Node
+	for it.Next() {
+		keys = append(keys, it.Node())
+	}
+	return keys
+}
+
+// child returns the table under key in m, creating it for dotted keys and
+// table headers. For an array of tables it is the last element.
+func (b *tomlBuilder) child(m *Node, key *unstable.Node) (*Node, error) {
+	name := string(key.Data)
+	f := m.field(name)
+	if f == nil {
+		pos := b.pos(key)
+		next := &Node{Kind: MapNode, Pos: pos}
+		m.Fields = append(m.Fields, &Field{Key: name, KeyPos: pos, Value: next})
+		return next, nil
+	}
+	switch {
+	case b.arrays[f.Value]:
+		return f.Value.Items[len(f.Value.Items)-1], nil
+	case f.Value.Kind == MapNode && !b.values[f.Value]:
+		return f.Value, nil
+	default:
+		return nil, posErrorf(b.pos(key), "key %q is already set to a value at %s", name, f.KeyPos)
+	}
+}
+
+// table returns the map a [table] or [[array table]] header opens.
+func (b *tomlBuilder) table(it unstable.Iterator, array bool) (*Node, error) {
+	keys := keyParts(it)
+	m := b.root
+	for _, k := range keys[:len(keys)-1] {
+		var err error
+		if m, err = b.child(m, k); err != nil {
+			return nil, err
+		}
+	}
+	last := keys[len(keys)-1]
+	pos := b.pos(last)
+	if !array {
+		if f := m.field(string(last.Data)); f != nil {
+			if prev, ok := b.headers[f.Value]; ok {
+				return nil, posErrorf(pos, "table %q is already defined at %s", string(last.Data), prev)
+			}
+		}
+		t, err := b.child(m, last)
+		if err != nil {
+			return nil, err
+		}
+		b.headers[t] = pos
+		return t, nil
+	}
+
+	elem := &Node{Kind: MapNode, Pos: pos}
+	f := m.field(string(last.Data))
+	switch {
+	case f == nil:
+		list := &Node{Kind: ListNode, Pos: pos, Items: []*Node{elem}}
+		b.arrays[list] = true
+		m.Fields = append(m.Fields, &Field{Key: string(last.Data), KeyPos: pos, Value: list})
+	case b.arrays[f.Value]:
+		f.Value.Items = append(f.Value.Items, elem)
+	default:
+		return nil, posErrorf(pos, "key %q is already set at %s and is not an array of tables", string(last.Data), f.KeyPos)
+	}
+	return elem, nil
+}
+
+func (b *tomlBuilder) keyValue(m *Node, expr *unstable.Node) error {
+	keys := keyParts(expr.Key())
+	for _, k := range keys[:len(keys)-1] {
+		var err error
+		if m, err = b.child(m, k); err != nil {
+			return err
+		}
+	}
+	last := keys[len(keys)-1]
+	pos := b.pos(last)
+	if f := m.field(string(last.Data)); f != nil {
+		return posErrorf(pos, "duplicate key %q, first set at %s", string(last.Data), f.KeyPos)
+	}
+	v, err := b.value(expr.Value(), pos)
+	if err != nil {
+		return err
+	}
+	m.Fields = append(m.Fields, &Field{Key: string(last.Data), KeyPos: pos, Value: v})
+	return nil
+}
+
+// value converts a TOML value; at is used for arrays, which have no
+// position of their own.
+func (b *tomlBuilder) value(n *unstable.Node, at Pos) (*Node, error) {
+	var out *Node
+	switch n.Kind {
+	case unstable.Array:
+		out = &Node{Kind: ListNode, Pos: at}
+		it := n.Children()
+		for it.Next() {
+			item, err := b.value(it.Node(), at)
+			if err != nil {
+				return nil, err
+			}
+			out.Items = append(out.Items, item)
+		}
+	case unstable.InlineTable:
+		out = &Node{Kind: MapNode, Pos: b.pos(n)}
+		it := n.Children()
+		for it.Next() {
+			if err := b.keyValue(out, it.Node()); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		out = &Node{Kind: ScalarNode, Pos: b.pos(n), Value: string(n.Data)}
+		switch n.Kind {
+		case unstable.Bool:
+			out.Type = BoolScalar
+		case unstable.Integer:
+			out.Type = IntScalar
+		case unstable.Float:
+			out.Type = FloatScalar
+		}
+	}
+	b.values[out] = true
+	return out, nil
+}
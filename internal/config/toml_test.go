@@ -0,0 +1,131 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTOMLSpec(t *testing.T) {
+	spec := decodePackage(t, parseTOML, "p.toml", `
+name = "app"
+ver = 1.10
+description = 'a # b' # trailing comment
+homepage = "https://example.com/#top"
+dotfiles = true
+tags = ["cli", "x, y"]
+packets = [{ name = "libc", ver = ">=2.31" }, "zlib 1.3"]
+scripts.post_install = "scripts/post.sh"
+
+[[targets]]
+path = "bin/*"
+mode = "0755"
+
+[[targets]]
+path = ["lib/*.so", "!lib/*.a"]
+min_files = 2
+`)
+	if spec.Version != "1.10" {
+		t.Errorf("ver: got %q, want %q", spec.Version, "1.10")
+	}
+	if spec.Description != "a # b" || spec.Homepage != "https://example.com/#top" {
+		t.Errorf("got description %q, homepage %q", spec.Description, spec.Homepage)
+	}
+	if !spec.Dotfiles {
+		t.Error("dotfiles: got false")
+	}
+	if want := (StringList{"cli", "x, y"}); !reflect.DeepEqual(spec.Tags, want) {
+		t.Errorf("tags: got %q, want %q", spec.Tags, want)
+	}
+	if want := []DependencySpec{{Name: "libc", Version: ">=2.31"}, {Name: "zlib", Version: "1.3"}}; !reflect.DeepEqual(spec.Packages, want) {
+		t.Errorf("packets: got %+v, want %+v", spec.Packages, want)
+	}
+	if spec.Scripts.PostInstall != "scripts/post.sh" {
+		t.Errorf("scripts: got %+v", spec.Scripts)
+	}
+	want := []TargetSpec{
+		{Patterns: []string{"bin/*"}, Mode: 0o755},
+		{Patterns: []string{"lib/*.so", "!lib/*.a"}, MinFiles: 2},
+	}
+	if !reflect.DeepEqual(spec.Targets, want) {
+		t.Errorf("targets: got %+v, want %+v", spec.Targets, want)
+	}
+}
+
+func TestTOMLPositions(t *testing.T) {
+	root, err := parseTOML("p.toml", []byte("name = \"app\"\n\n[[targets]]\npath = \"bin/*\"\n  min_files = 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := root.Get("targets").Items[0]
+	tests := []struct {
+		node *Node
+		want string
+	}{
+		{root.Get("name"), "p.toml:1:8"},
+		{target, "p.toml:3:3"},
+		{target.Get("path"), "p.toml:4:8"},
+		{target.Get("min_files"), "p.toml:5:15"},
+	}
+	for _, tt := range tests {
+		if got := tt.node.Pos.String(); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.node.Value, got, tt.want)
+		}
+	}
+}
+
+func TestTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{name: "duplicate key", src: "name = \"a\"\nver = \"1\"\nname = \"b\"\n", err: `p.toml:3:1: duplicate key "name", first set at p.toml:1:1`},
+		{name: "duplicate in table", src: "[scripts]\npre_install = \"a\"\n pre_install = \"b\"\n", err: `p.toml:3:2: duplicate key "pre_install", first set at p.toml:2:1`},
+		{name: "duplicate in inline table", src: "packets = [{ name = \"a\", name = \"b\" }]\n", err: `p.toml:1:26: duplicate key "name", first set at p.toml:1:14`},
+		{name: "table redefined", src: "[scripts]\n\n[scripts]\n", err: `p.toml:3:2: table "scripts" is already defined at p.toml:1:2`},
+		{name: "table over value", src: "scripts = {}\n[scripts]\n", err: `p.toml:2:2: key "scripts" is already set to a value at p.toml:1:1`},
+		{name: "dotted key over value", src: "scripts = \"x\"\nscripts.pre_install = \"a\"\n", err: `p.toml:2:1: key "scripts" is already set to a value at p.toml:1:1`},
+		{name: "array table over table", src: "[targets]\n[[targets]]\n", err: `p.toml:2:3: key "targets" is already set at p.toml:1:2 and is not an array of tables`},
+		{name: "syntax", src: "name = \"a\"\nver = \n", err: "p.toml:2:7: incomplete number"},
+		{name: "unterminated string", src: "name = \"a\n", err: "p.toml:1:10: basic strings cannot have new lines"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTOML("p.toml", []byte(tt.src))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// A [table] header below an array of tables extends its last element.
+func TestTOMLArrayTablesExtend(t *testing.T) {
+	root, err := parseTOML("p.toml", []byte(`
+[[packets]]
+name = "libc"
+
+[[packets]]
+name = "zlib"
+
+[packets.meta]
+x = "1"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets := root.Get("packets")
+	if packets.Kind != ListNode || len(packets.Items) != 2 {
+		t.Fatalf("packets: got %+v", packets)
+	}
+	if packets.Items[0].Get("meta") != nil {
+		t.Error("meta was added to the first packet")
+	}
+	if x := packets.Items[1].Get("meta").Get("x"); x == nil || x.Value != "1" {
+		t.Errorf("meta.x: got %+v", x)
+	}
+}
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Pos is a position in a spec file; Line and Column start at 1.
+type Pos struct {
+	File   string `json:"file"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+}
+
+func (p Pos) String() string {
+	switch {
+	case p.Line == 0:
+		return p.File
+	case p.Column == 0:
+		return fmt.Sprintf("%s:%d", p.File, p.Line)
+	default:
+		return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+	}
+}
+
+type NodeKind int
+
+const (
+	NullNode NodeKind = iota
+	ScalarNode
+	ListNode
+	MapNode
+)
+
+// ScalarType is the type a scalar has in the source format. The spec schema
+// decides what it becomes: a version written as 1.10 stays "1.10".
+type ScalarType int
+
+const (
+	StringScalar ScalarType = iota
+	IntScalar
+	FloatScalar
+	BoolScalar
+)
+
+// Node is a format-independent spec document: YAML and TOML files are parsed
+// into it before they are checked against the schema and decoded.
+type Node struct {
+	Kind NodeKind
+	Pos  Pos
+	// Value is the scalar's text as written, without quotes.
+	Value  string
+	Type   ScalarType
+	Items  []*Node
+	Fields []*Field
+}
+
+// Field is a key of a map node; fields keep their order from the file.
+type Field struct {
+	Key    string
+	KeyPos Pos
+	Value  *Node
+}
+
+// Get returns the value of a map key, or nil.
+func (n *Node) Get(key string) *Node {
+	if f := n.field(key); f != nil {
+		return f.Value
+	}
+	return nil
+}
+
+func (n *Node) field(key string) *Field {
+	if n == nil || n.Kind != MapNode {
+		return nil
+	}
+	for _, f := range n.Fields {
+		if f.Key == key {
+			return f
+		}
+	}
+	return nil
+}
+
+// decode converts the node to the plain values encoding/json produces,
+// guided by the schema. Scalars become strings wherever the schema expects
+// one, whatever type the source format gave them. Keys the schema does not
+// know are converted by their own type.
+func (n *Node) decode(s *shape, path string) (any, error) {
+	if s != nil {
+		if msg := n.mismatch(s); msg != "" {
+			return nil, n.errorf(path, "%s", msg)
+		}
+	}
+	switch n.Kind {
+	case NullNode:
+		return nil, nil
+	case ScalarNode:
+		switch {
+		case s == nil:
+			return n.natural(), nil
+		case s.scalar == scalarBool:
+			return strings.EqualFold(n.Value, "true"), nil
+		case s.scalar == scalarInt:
+			return parseInt(n.Value)
+		default:
+			return n.Value, nil
+		}
+	case ListNode:
+		var items *shape
+		if s != nil {
+			items = s.items
+		}
+		out := make([]any, 0, len(n.Items))
+		for i, item := range n.Items {
+			v, err := item.decode(items, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	default:
+		out := make(map[string]any, len(n.Fields))
+		for _, f := range n.Fields {
+			var field *shape
+			if s != nil {
+				field = s.fields[f.Key]
+			}
+			v, err := f.Value.decode(field, joinPath(path, f.Key))
+			if err != nil {
+				return nil, err
+			}
+			out[f.Key] = v
+		}
+		return out, nil
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// mismatch describes how the node does not fit s, or returns "".
+func (n *Node) mismatch(s *shape) string {
+	switch n.Kind {
+	case ScalarNode:
+		switch s.scalar {
+		case scalarString:
+		case scalarBool:
+			if n.Type != BoolScalar {
+				return fmt.Sprintf("expected true or false, got %q", n.Value)
+			}
+		case scalarInt:
+			if _, err := parseInt(n.Value); n.Type != IntScalar || err != nil {
+				return fmt.Sprintf("expected an integer, got %q", n.Value)
+			}
+		default:
+			return fmt.Sprintf("expected %s, got %q", s.expected(), n.Value)
+		}
+	case ListNode:
+		if s.items == nil {
+			return fmt.Sprintf("expected %s, got a list", s.expected())
+		}
+	case MapNode:
+		if !s.object {
+			return fmt.Sprintf("expected %s, got a map", s.expected())
+		}
+	}
+	return ""
+}
+
+// natural converts a scalar by its source type.
+func (n *Node) natural() any {
+	switch n.Type {
+	case BoolScalar:
+		return strings.EqualFold(n.Value, "true")
+	case IntScalar:
+		if i, err := parseInt(n.Value); err == nil {
+			return i
+		}
+	case FloatScalar:
+		if f, err := strconv.ParseFloat(strings.ReplaceAll(n.Value, "_", ""), 64); err == nil {
+			return f
+		}
+	}
+	return n.Value
+}
+
+func (n *Node) errorf(path, format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	if path != "" {
+		msg = path + ": " + msg
+	}
+	return posErrorf(n.Pos, "%s", msg)
+}
+
+// PosError is an error in a spec file at a known position.
+type PosError struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *PosError) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+func posErrorf(pos Pos, format string, args ...any) error {
+	return &PosError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// parseInt accepts the integer forms of YAML and TOML: 0x, 0o and 0b
+// prefixes and "_" separators.
+func parseInt(s string) (int64, error) {
+	s = strings.ReplaceAll(s, "_", "")
+	sign := ""
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		sign, s = s[:1], s[1:]
+	}
+	if len(s) > 1 && s[0] == '0' && strings.IndexByte("xob", s[1]) < 0 {
+		// A leading zero is not octal in YAML 1.2 or TOML.
+		return strconv.ParseInt(sign+s, 10, 64)
+	}
+	return strconv.ParseInt(sign+s, 0, 64)
+}
@@ -0,0 +1,306 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// validName is what a package name may look like; it ends up in archive
+// and manifest file names.
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+
+type ValidateOptions struct {
+	// CheckVersion and CheckConstraint parse a version and a version
+	// constraint the way update does.
+	CheckVersion    func(string) error
+	CheckConstraint func(string) error
+}
+
+type Validation struct {
+	Spec     string    `json:"spec"`
+	Kind     string    `json:"kind,omitempty"`
+	Format   string    `json:"format,omitempty"`
+	Problems []Problem `json:"problems"`
+}
+
+// Problem is one thing wrong with a spec; Path is the field, as in
+// "targets[0].mode".
+type Problem struct {
+	Pos     Pos    `json:"pos"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Path == "" {
+		return p.Pos.String() + ": " + p.Message
+	}
+	return p.Pos.String() + ": " + p.Path + ": " + p.Message
+}
+
+// Validate checks a package, update or workspace spec strictly, after
+// resolving extends and include: unlike loading it, unknown fields are
+// errors. Versions, constraints, names and
+// platforms are checked too, and every problem is reported with its
+// position. Values using ${...} substitutions are not checked. A syntax
+// error is reported as the only problem; the error is set when the file
+// cannot be read.
+func Validate(path string, opts ValidateOptions) (*Validation, error) {
+	root, format, err := loadSpecTree(path)
+	if err != nil {
+		var posErr *PosError
+		if !errors.As(err, &posErr) {
+			return nil, err
+		}
+		return &Validation{Spec: path, Problems: []Problem{{Pos: posErr.Pos, Message: posErr.Msg}}}, nil
+	}
+	v := &validator{opts: opts}
+	res := &Validation{Spec: path, Kind: specKind(root), Format: format}
+	if root.Kind != MapNode {
+		v.report(root, "", "spec must be a map")
+		res.Problems = v.problems
+		return res, nil
+	}
+
+	v.check(root, specShapes[res.Kind], "")
+	switch res.Kind {
+	case SpecPackage:
+		v.packageValues(root)
+	case SpecUpdate:
+		v.dependencies(root.Get("packages"), "packages", "")
+	case SpecWorkspace:
+		if defaults := root.Get("defaults"); defaults != nil {
+			v.dependencies(defaults.Get("packets"), "defaults.packets", "")
+		}
+	}
+
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i].Pos, v.problems[j].Pos
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	res.Problems = v.problems
+	return res, nil
+}
+
+type validator struct {
+	opts     ValidateOptions
+	problems []Problem
+}
+
+func (v *validator) report(n *Node, path, format string, args ...any) {
+	v.reportAt(n.Pos, path, format, args...)
+}
+
+func (v *validator) reportAt(pos Pos, path, format string, args ...any) {
+	v.problems = append(v.problems, Problem{Pos: pos, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// check reports every place where n does not fit s.
+func (v *validator) check(n *Node, s *shape, path string) {
+	if n.Kind == NullNode {
+		return
+	}
+	if msg := n.mismatch(s); msg != "" {
+		v.report(n, path, "%s", msg)
+		return
+	}
+	switch n.Kind {
+	case ListNode:
+		for i, item := range n.Items {
+			v.check(item, s.items, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case MapNode:
+		for _, f := range n.Fields {
+			field := s.fields[f.Key]
+			if field == nil && s.extensions && strings.HasPrefix(f.Key, "x-") {
+				continue
+			}
+			if field == nil {
+				msg := fmt.Sprintf("unknown field %q", f.Key)
+				if known := closestKey(f.Key, s.fields); known != "" {
+					msg += fmt.Sprintf(", did you mean %q?", known)
+				}
+				v.reportAt(f.KeyPos, path, "%s", msg)
+				continue
+			}
+			v.check(f.Value, field, joinPath(path, f.Key))
+		}
+		for _, key := range s.required {
+			if n.Get(key) == nil {
+				v.report(n, path, "missing required field %q", key)
+			}
+		}
+	}
+}
+
+// text returns a scalar's value if it can be checked: it is a scalar and
+// does not depend on ${...} substitutions.
+func text(n *Node) (string, bool) {
+	if n == nil || n.Kind != ScalarNode || strings.Contains(n.Value, "${") {
+		return "", false
+	}
+	return n.Value, true
+}
+
+func (v *validator) packageValues(root *Node) {
+	name, _ := text(root.Get("name"))
+	v.name(root.Get("name"), "name")
+	if ver, ok := text(root.Get("ver")); ok && v.opts.CheckVersion != nil {
+		if err := v.opts.CheckVersion(ver); err != nil {
+			v.report(root.Get("ver"), "ver", "invalid version %q: %v", ver, err)
+		}
+	}
+	if s, ok := text(root.Get("os")); ok {
+		if _, err := NewPlatform(s, ""); err != nil {
+			v.report(root.Get("os"), "os", "%v", err)
+		}
+	}
+	if s, ok := text(root.Get("arch")); ok {
+		if _, err := NewPlatform("", s); err != nil {
+			v.report(root.Get("arch"), "arch", "%v", err)
+		}
+	}
+	if s, ok := text(root.Get("license")); ok {
+		if _, err := NormalizeLicense(s); err != nil {
+			v.report(root.Get("license"), "license", "%v", err)
+		}
+	}
+	if targets := root.Get("targets"); targets != nil && targets.Kind == ListNode {
+		for i, t := range targets.Items {
+			path := fmt.Sprintf("targets[%d]", i)
+			if mode, ok := text(t.Get("mode")); ok {
+				if _, err := parseMode(mode); err != nil {
+					v.report(t.Get("mode"), path+".mode", "%v", err)
+				}
+			}
+			if n := t.Get("min_files"); n != nil && n.Type == IntScalar {
+				if count, err := parseInt(n.Value); err == nil && count < 0 {
+					v.report(n, path+".min_files", "must not be negative")
+				}
+			}
+		}
+	}
+	for _, field := range []string{"packets", "conflicts", "provides", "replaces"} {
+		v.dependencies(root.Get(field), field, name)
+	}
+}
+
+func (v *validator) name(n *Node, path string) {
+	if s, ok := text(n); ok {
+		v.nameAt(n.Pos, path, s)
+	}
+}
+
+func (v *validator) nameAt(pos Pos, path, name string) {
+	if !validName.MatchString(name) {
+		v.reportAt(pos, path, "invalid package name %q: use letters, digits, '.', '_', '+' and '-', starting with a letter or digit", name)
+	}
+}
+
+// dependencies checks a dependency list: a list of entries, or for
+// conflicts, provides and replaces a single one. self is the name of the
+// package declaring them.
+func (v *validator) dependencies(n *Node, field, self string) {
+	if n == nil {
+		return
+	}
+	if n.Kind != ListNode {
+		v.dependency(n, field, self)
+		return
+	}
+	for i, item := range n.Items {
+		v.dependency(item, fmt.Sprintf("%s[%d]", field, i), self)
+	}
+}
+
+func (v *validator) dependency(n *Node, path, self string) {
+	field, _, _ := strings.Cut(path, "[")
+	relation := field == "conflicts" || field == "provides" || field == "replaces"
+	nameNode, verNode := n, n
+	var name, ver string
+	switch n.Kind {
+	case ScalarNode:
+		if s, ok := text(n); ok {
+			dep := parseDependency(s)
+			name, ver = dep.Name, dep.Version
+			v.nameAt(n.Pos, path, name)
+		}
+	case MapNode:
+		nameNode, verNode = n.Get("name"), n.Get("ver")
+		name, _ = text(nameNode)
+		ver, _ = text(verNode)
+		v.name(nameNode, path+".name")
+		for _, key := range []string{"optional", "with"} {
+			if f := n.field(key); f != nil && relation {
+				v.reportAt(f.KeyPos, path+"."+key, "only applies to packets")
+			}
+		}
+		if with := n.Get("with"); with != nil && with.Kind == ListNode {
+			for i, item := range with.Items {
+				v.name(item, fmt.Sprintf("%s.with[%d]", path, i))
+			}
+		}
+	default:
+		return
+	}
+
+	if name != "" && name == self {
+		v.report(nameNode, path, "package %s cannot name itself", self)
+	}
+	if ver == "" {
+		return
+	}
+	if field == "provides" {
+		exact := strings.TrimSpace(strings.TrimLeft(ver, "="))
+		if strings.ContainsAny(exact, "<>=") {
+			v.report(verNode, path, "%s must be an exact version, not a constraint", ver)
+		} else if v.opts.CheckVersion != nil {
+			if err := v.opts.CheckVersion(exact); err != nil {
+				v.report(verNode, path, "invalid version %q: %v", ver, err)
+			}
+		}
+		return
+	}
+	if v.opts.CheckConstraint != nil {
+		if err := v.opts.CheckConstraint(ver); err != nil {
+			v.report(verNode, path, "invalid constraint %q: %v", ver, err)
+		}
+	}
+}
+
+// closestKey suggests a known key for a misspelt one, or returns "".
+func closestKey(key string, fields map[string]*shape) string {
+	best, bestDist := "", len(key)/2+1
+	for known := range fields {
+		if d := editDistance(key, known); d < bestDist || (d == bestDist && best != "" && known < best) {
+			best, bestDist = known, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
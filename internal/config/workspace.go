@@ -0,0 +1,138 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// WorkspaceSpec lists member package specs (paths or filepath.Glob patterns
+// relative to the workspace file) and defaults shared by all of them.
+type WorkspaceSpec struct {
+	Members  StringList   `json:"members" yaml:"members"`
+	Defaults SpecDefaults `json:"defaults" yaml:"defaults"`
+}
+
+type SpecDefaults struct {
+	Targets     []TargetSpec     `json:"targets" yaml:"targets"`
+	Exclude     StringList       `json:"exclude" yaml:"exclude"`
+	Packages    []DependencySpec `json:"packets" yaml:"packets"`
+	Format      string           `json:"format" yaml:"format"`
+	Compression string           `json:"compression" yaml:"compression"`
+}
+
+// apply fills in what a member spec leaves out: targets and format settings
+// when absent, constraints for dependencies listed without "ver", and the
+// shared exclude list ahead of the member's own.
+func (d *SpecDefaults) apply(spec *PackageSpec) {
+	if len(spec.Targets) == 0 {
+		// Members interpolate their targets in place, so each gets a copy.
+		for _, target := range d.Targets {
+			target.Patterns = append([]string(nil), target.Patterns...)
+			target.Exclude = append([]string(nil), target.Exclude...)
+			spec.Targets = append(spec.Targets, target)
+		}
+	}
+	if len(d.Exclude) > 0 {
+		spec.Exclude = append(append(StringList(nil), d.Exclude...), spec.Exclude...)
+	}
+	for i := range spec.Packages {
+		if spec.Packages[i].Version != "" {
+			continue
+		}
+		for _, dep := range d.Packages {
+			if dep.Name == spec.Packages[i].Name {
+				spec.Packages[i].Version = dep.Version
+			}
+		}
+	}
+	if spec.Format == "" {
+		spec.Format = d.Format
+	}
+	if spec.Compression == "" {
+		spec.Compression = d.Compression
+	}
+}
+
+type Workspace struct {
+	Path    string
+	Members []WorkspaceMember
+}
+
+type WorkspaceMember struct {
+	SpecPath string
+	Dir      string
+	Spec     *PackageSpec
+}
+
+// IsWorkspace reports whether the spec at path is a workspace, i.e. has a
+// top-level "members" key.
+func IsWorkspace(path string) (bool, error) {
+	var probe map[string]json.RawMessage
+	if _, err := decodeSpecFile(path, nil, &probe); err != nil {
+		return false, err
+	}
+	_, ok := probe["members"]
+	return ok, nil
+}
+
+func LoadWorkspace(path string) (*Workspace, error) {
+	ws := &WorkspaceSpec{}
+	if _, err := decodeSpecFile(path, workspaceShape, ws); err != nil {
+		return nil, err
+	}
+	if len(ws.Members) == 0 {
+		return nil, errors.New("workspace must list members")
+	}
+
+	self, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	baseDir := filepath.Dir(path)
+
+	var specPaths []string
+	seen := map[string]bool{self: true}
+	for _, pattern := range ws.Members {
+		matches, err := filepath.Glob(filepath.Join(baseDir, filepath.FromSlash(pattern)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid member pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("member pattern %q matched no specs", pattern)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			abs, err := filepath.Abs(match)
+			if err != nil {
+				return nil, err
+			}
+			if seen[abs] {
+				continue
+			}
+			seen[abs] = true
+			specPaths = append(specPaths, match)
+		}
+	}
+
+	result := &Workspace{Path: path}
+	names := map[string]string{}
+	for _, specPath := range specPaths {
+		spec, err := loadPackageSpec(specPath, &ws.Defaults)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", specPath, err)
+		}
+		if other, ok := names[spec.Name]; ok {
+			return nil, fmt.Errorf("package %s is defined by both %s and %s", spec.Name, other, specPath)
+		}
+		names[spec.Name] = specPath
+		result.Members = append(result.Members, WorkspaceMember{
+			SpecPath: specPath,
+			Dir:      filepath.Dir(specPath),
+			Spec:     spec,
+		})
+	}
+	return result, nil
+}
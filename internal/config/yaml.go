@@ -0,0 +1,166 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlLineErr matches the position yaml.v3 puts into syntax errors.
+var yamlLineErr = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// parseYAML parses a single YAML 1.2 document into a Node tree. Anchors,
+// aliases and "<<" merge keys are resolved; scalars keep their source text.
+func parseYAML(path string, data []byte) (*Node, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	var doc yaml.Node
+	if err := dec.Decode(&doc); err != nil {
+		if err == io.EOF {
+			return nil, posErrorf(Pos{File: path}, "empty YAML document")
+		}
+		return nil, yamlError(path, err)
+	}
+	var extra yaml.Node
+	if err := dec.Decode(&extra); err != io.EOF {
+		if err != nil {
+			return nil, yamlError(path, err)
+		}
+		return nil, posErrorf(Pos{File: path, Line: extra.Line}, "a spec file must hold a single YAML document")
+	}
+	if len(doc.Content) == 0 {
+		return nil, posErrorf(Pos{File: path}, "empty YAML document")
+	}
+	c := &yamlConverter{path: path, active: map[*yaml.Node]bool{}}
+	return c.convert(doc.Content[0])
+}
+
+// yamlError moves the line yaml.v3 puts into "yaml: line 3: msg" into
+// the error position.
+func yamlError(path string, err error) error {
+	msg := err.Error()
+	if m := yamlLineErr.FindStringSubmatch(msg); m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return posErrorf(Pos{File: path, Line: line}, "%s", msg[len(m[0]):])
+	}
+	return posErrorf(Pos{File: path}, "%s", strings.TrimPrefix(msg, "yaml: "))
+}
+
+type yamlConverter struct {
+	path string
+	// active holds the anchors being expanded, to catch recursive aliases.
+	active map[*yaml.Node]bool
+}
+
+func (c *yamlConverter) pos(n *yaml.Node) Pos {
+	return Pos{File: c.path, Line: n.Line, Column: n.Column}
+}
+
+func (c *yamlConverter) convert(n *yaml.Node) (*Node, error) {
+	switch n.Kind {
+	case yaml.AliasNode:
+		if c.active[n.Alias] {
+			return nil, posErrorf(c.pos(n), "alias *%s refers to itself", n.Value)
+		}
+		c.active[n.Alias] = true
+		defer delete(c.active, n.Alias)
+		return c.convert(n.Alias)
+	case yaml.ScalarNode:
+		out := &Node{Kind: ScalarNode, Pos: c.pos(n), Value: n.Value}
+		switch n.ShortTag() {
+		case "!!null":
+			out.Kind, out.Value = NullNode, ""
+		case "!!bool":
+			out.Type = BoolScalar
+		case "!!int":
+			out.Type = IntScalar
+		case "!!float":
+			out.Type = FloatScalar
+		}
+		return out, nil
+	case yaml.SequenceNode:
+		out := &Node{Kind: ListNode, Pos: c.pos(n)}
+		for _, item := range n.Content {
+			v, err := c.convert(item)
+			if err != nil {
+				return nil, err
+			}
+			out.Items = append(out.Items, v)
+		}
+		return out, nil
+	case yaml.MappingNode:
+		out := &Node{Kind: MapNode, Pos: c.pos(n)}
+		if err := c.mapping(out, n, false); err != nil {
+			return nil, err
+		}
+		return out, nil
+	default:
+		return nil, posErrorf(c.pos(n), "unexpected YAML node")
+	}
+}
+
+// mapping adds the keys of n to out. Merged keys never override keys the
+// mapping sets itself, wherever the "<<" is written.
+func (c *yamlConverter) mapping(out *Node, n *yaml.Node, merged bool) error {
+	var merges []*yaml.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		if k.Kind != yaml.ScalarNode {
+			return posErrorf(c.pos(k), "map keys must be scalars")
+		}
+		if k.ShortTag() == "!!merge" {
+			merges = append(merges, v)
+			continue
+		}
+		if prev := out.field(k.Value); prev != nil {
+			if merged {
+				continue
+			}
+			return posErrorf(c.pos(k), "duplicate key %q, first set at %s", k.Value, prev.KeyPos)
+		}
+		value, err := c.convert(v)
+		if err != nil {
+			return err
+		}
+		out.Fields = append(out.Fields, &Field{Key: k.Value, KeyPos: c.pos(k), Value: value})
+	}
+	for _, m := range merges {
+		if err := c.merge(out, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge applies the value of a "<<" key: a map, or a list of maps where
+// earlier ones win.
+func (c *yamlConverter) merge(out *Node, v *yaml.Node) error {
+	target := v
+	if v.Kind == yaml.AliasNode {
+		target = v.Alias
+	}
+	switch target.Kind {
+	case yaml.MappingNode:
+		if c.active[target] {
+			return posErrorf(c.pos(v), "merge refers to its own map")
+		}
+		c.active[target] = true
+		defer delete(c.active, target)
+		return c.mapping(out, target, true)
+	case yaml.SequenceNode:
+		for _, item := range target.Content {
+			if item.Kind == yaml.SequenceNode {
+				return posErrorf(c.pos(item), "merge list entries must be maps")
+			}
+			if err := c.merge(out, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return posErrorf(c.pos(v), "merge value must be a map or a list of maps")
+	}
+}
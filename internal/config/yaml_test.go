@@ -0,0 +1,170 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// decodePackage parses src with parse and decodes it against the package
+// schema, without the checks LoadPackageSpec adds.
+func decodePackage(t *testing.T, parse func(string, []byte) (*Node, error), name, src string) *PackageSpec {
+	t.Helper()
+	root, err := parse(name, []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &PackageSpec{}
+	if err := decodeNode(root, packageShape, spec); err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+func TestYAMLScalars(t *testing.T) {
+	spec := decodePackage(t, parseYAML, "p.yaml", `
+name: app
+ver: 1.10
+description: 'a # b' # trailing comment
+homepage: "https://example.com/#top"
+dotfiles: true
+targets:
+  - path: bin/*
+    mode: 0755
+  - path: [lib/*.so, "!lib/*.a"]
+    min_files: 2
+tags: [cli, "x, y"]
+packets: [{name: libc, ver: ">=2.31"}, zlib 1.3]
+`)
+	if spec.Version != "1.10" {
+		t.Errorf("ver: got %q, want %q", spec.Version, "1.10")
+	}
+	if spec.Description != "a # b" {
+		t.Errorf("description: got %q", spec.Description)
+	}
+	if spec.Homepage != "https://example.com/#top" {
+		t.Errorf("homepage: got %q", spec.Homepage)
+	}
+	if !spec.Dotfiles {
+		t.Error("dotfiles: got false")
+	}
+	if spec.Targets[0].Mode != 0o755 {
+		t.Errorf("mode: got %o, want 755", spec.Targets[0].Mode)
+	}
+	if want := []string{"lib/*.so", "!lib/*.a"}; !reflect.DeepEqual(spec.Targets[1].Patterns, want) || spec.Targets[1].MinFiles != 2 {
+		t.Errorf("targets[1]: got %+v", spec.Targets[1])
+	}
+	if want := (StringList{"cli", "x, y"}); !reflect.DeepEqual(spec.Tags, want) {
+		t.Errorf("tags: got %q, want %q", spec.Tags, want)
+	}
+	want := []DependencySpec{{Name: "libc", Version: ">=2.31"}, {Name: "zlib", Version: "1.3"}}
+	if !reflect.DeepEqual(spec.Packages, want) {
+		t.Errorf("packets: got %+v, want %+v", spec.Packages, want)
+	}
+}
+
+func TestYAMLAnchorsAndMergeKeys(t *testing.T) {
+	spec := decodePackage(t, parseYAML, "p.yaml", `
+x-target: &bin
+  path: bin/*
+  mode: "0700"
+  dest: usr/bin
+x-extra: &extra
+  dest: opt/bin
+  dotfiles: true
+name: app
+ver: "1"
+targets:
+  - *bin
+  - <<: [*bin, *extra]
+    path: sbin/*
+  - dest: own
+    <<: *extra
+    path: etc/*
+`)
+	want := []TargetSpec{
+		{Patterns: []string{"bin/*"}, Dest: "usr/bin", Mode: 0o700},
+		// Earlier maps in a merge list win, and the mapping's own keys
+		// win over all of them.
+		{Patterns: []string{"sbin/*"}, Dest: "usr/bin", Mode: 0o700, Dotfiles: true},
+		{Patterns: []string{"etc/*"}, Dest: "own", Dotfiles: true},
+	}
+	if !reflect.DeepEqual(spec.Targets, want) {
+		t.Errorf("got %+v, want %+v", spec.Targets, want)
+	}
+}
+
+func TestYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{name: "duplicate key", src: "name: a\nver: \"1\"\nname: b\n", err: `p.yaml:3:1: duplicate key "name", first set at p.yaml:1:1`},
+		{name: "nested duplicate key", src: "targets:\n  - path: a\n    path: b\n", err: `p.yaml:3:5: duplicate key "path", first set at p.yaml:2:5`},
+		{name: "syntax", src: "name: a\n  ver: 1\n", err: "p.yaml:2: mapping values are not allowed in this context"},
+		{name: "unclosed flow", src: "tags: [a, b\n", err: "p.yaml:1: did not find expected ',' or ']'"},
+		{name: "empty", src: "# nothing\n", err: "p.yaml: empty YAML document"},
+		{name: "two documents", src: "name: a\n---\nname: b\n", err: "p.yaml:2: a spec file must hold a single YAML document"},
+		{name: "recursive merge", src: "a: &a\n  <<: *a\n", err: "p.yaml:2:7: merge refers to its own map"},
+		{name: "recursive alias", src: "a: &a\n  b: *a\n", err: "p.yaml:2:6: alias *a refers to itself"},
+		{name: "bad merge value", src: "a:\n  <<: 1\n", err: "p.yaml:2:7: merge value must be a map or a list of maps"},
+		{name: "complex key", src: "? [a]\n: 1\n", err: "p.yaml:1:3: map keys must be scalars"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseYAML("p.yaml", []byte(tt.src))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestYAMLSchemaErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{name: "bool", src: "name: a\ndotfiles: yes\n", err: `p.yaml:2:11: dotfiles: expected true or false, got "yes"`},
+		{name: "integer", src: "targets:\n  - path: a\n    min_files: 1.5\n", err: `p.yaml:3:16: targets[0].min_files: expected an integer, got "1.5"`},
+		{name: "list for string", src: "name: [a]\n", err: "p.yaml:1:7: name:"},
+		{name: "string for list", src: "targets: bin/*\n", err: `p.yaml:1:10: targets: expected a list, got "bin/*"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := parseYAML("p.yaml", []byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = decodeNode(root, packageShape, &PackageSpec{})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoadPackageSpecYAML(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pm.yaml")
+	if err := os.WriteFile(path, []byte("name: app\nver: 2.0\ntargets: [bin/*]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := LoadPackageSpec(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Version != "2.0" || !reflect.DeepEqual(spec.Targets, []TargetSpec{{Patterns: []string{"bin/*"}}}) {
+		t.Errorf("got %+v", spec)
+	}
+}
@@ -0,0 +1,370 @@
+package packager
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pm/internal/config"
+)
+
+type compiledTarget struct {
+	spec     config.TargetSpec
+	patterns globList
+	exclude  globList
+}
+
+func compileTarget(spec *config.PackageSpec, baseDir string, target config.TargetSpec) (*compiledTarget, error) {
+	opts := globOptions{
+		dotfiles:   spec.Dotfiles || target.Dotfiles,
+		ignoreCase: spec.IgnoreCase || target.IgnoreCase,
+	}
+	var patterns []string
+	for _, pattern := range target.Patterns {
+		negate := strings.HasPrefix(pattern, "!")
+		cleaned := strings.TrimPrefix(pattern, "!")
+		cleaned = strings.TrimPrefix(cleaned, "./")
+		cleaned = strings.TrimPrefix(cleaned, baseDir+"/")
+		cleaned = filepath.ToSlash(cleaned)
+		if negate {
+			cleaned = "!" + cleaned
+		}
+		patterns = append(patterns, cleaned)
+	}
+	compiled, err := compileGlobList(patterns, opts, false)
+	if err != nil {
+		return nil, fmt.Errorf("target %s: %w", target, err)
+	}
+	exclude, err := compileGlobList(target.Exclude, opts, true)
+	if err != nil {
+		return nil, fmt.Errorf("target %s: exclude: %w", target, err)
+	}
+	return &compiledTarget{spec: target, patterns: compiled, exclude: exclude}, nil
+}
+
+// evaluate returns the pattern that selects segs, or the reason the target
+// rejects it. Both are empty when the target does not match segs at all.
+func (t *compiledTarget) evaluate(segs []string) (*glob, string) {
+	g := t.patterns.decide(segs)
+	if g == nil {
+		return nil, ""
+	}
+	if g.negate {
+		return nil, fmt.Sprintf("target %s: %s", t.spec, g.source)
+	}
+	if ex := t.exclude.decide(segs); ex != nil && !ex.negate {
+		return nil, fmt.Sprintf("target %s exclude: %s", t.spec, ex.source)
+	}
+	return g, ""
+}
+
+// collector walks the working directory once for all targets.
+type collector struct {
+	baseDir string
+	follow  bool
+	spec    *config.PackageSpec
+	explain bool
+	targets []*compiledTarget
+	exclude globList
+	ignore  *ignoreMatcher
+
+	owners   map[string]string
+	files    []fileRef
+	claimed  []int
+	excluded []ExcludedPath
+}
+
+// collectFiles selects the files to package. With explain set it also keeps
+// track of excluded paths and of how many files each target selected.
+func collectFiles(spec *config.PackageSpec, baseDir string, follow, explain bool) (*collector, error) {
+	c := &collector{
+		baseDir: baseDir,
+		follow:  follow,
+		explain: explain,
+		spec:    spec,
+		ignore:  newIgnoreMatcher(baseDir),
+		owners:  map[string]string{},
+		claimed: make([]int, len(spec.Targets)),
+	}
+	exclude, err := compileGlobList(spec.Exclude, globOptions{dotfiles: spec.Dotfiles, ignoreCase: spec.IgnoreCase}, true)
+	if err != nil {
+		return nil, fmt.Errorf("exclude: %w", err)
+	}
+	c.exclude = exclude
+	for _, target := range spec.Targets {
+		compiled, err := compileTarget(spec, baseDir, target)
+		if err != nil {
+			return nil, err
+		}
+		c.targets = append(c.targets, compiled)
+	}
+
+	info, err := os.Stat(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.walk(baseDir, nil, info, map[string]bool{}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(c.files, func(i, j int) bool { return c.files[i].Path < c.files[j].Path })
+	return c, nil
+}
+
+// unmatched lists targets that did not select a single file.
+func (c *collector) unmatched() []string {
+	var out []string
+	for i, n := range c.claimed {
+		if n == 0 {
+			out = append(out, c.targets[i].spec.String())
+		}
+	}
+	return out
+}
+
+// unsatisfied returns one message per target that selected fewer files than
+// it asks for with required or min_files.
+func (c *collector) unsatisfied() []string {
+	var problems []string
+	for i, target := range c.targets {
+		min := target.spec.MinFiles
+		if target.spec.Required && min < 1 {
+			min = 1
+		}
+		if c.claimed[i] < min {
+			problems = append(problems, fmt.Sprintf("target %s matched %d files, at least %d required", target.spec, c.claimed[i], min))
+		}
+	}
+	return problems
+}
+
+func (c *collector) walk(abs string, segs []string, info fs.FileInfo, active map[string]bool) error {
+	if c.follow {
+		real, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			return err
+		}
+		if active[real] {
+			return fmt.Errorf("symlink loop at %s", strings.Join(segs, "/"))
+		}
+		active[real] = true
+		defer delete(active, real)
+	}
+
+	entries, err := os.ReadDir(abs)
+	if err != nil {
+		return err
+	}
+	// Directories are only packaged on their own when empty; otherwise
+	// their contents carry them.
+	if len(segs) > 0 && len(entries) == 0 {
+		return c.consider(segs, true)
+	}
+
+	for _, entry := range entries {
+		childAbs := filepath.Join(abs, entry.Name())
+		childSegs := append(segs[:len(segs):len(segs)], entry.Name())
+		childInfo, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if c.follow && childInfo.Mode()&fs.ModeSymlink != 0 {
+			childInfo, err = os.Stat(childAbs)
+			if err != nil {
+				return fmt.Errorf("cannot follow symlink %s: %w", strings.Join(childSegs, "/"), err)
+			}
+		}
+
+		isDir := childInfo.IsDir()
+		reason, err := c.skipped(childSegs, isDir)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			if c.explain && c.wanted(childSegs, isDir) {
+				c.recordExcluded(childSegs, isDir, reason)
+			}
+			continue
+		}
+
+		if isDir {
+			descend, reason := c.shouldDescend(childSegs)
+			if descend {
+				if err := c.walk(childAbs, childSegs, childInfo, active); err != nil {
+					return err
+				}
+				continue
+			}
+			// A pattern that names the directory itself, like "empty" or
+			// "dir/*", has nothing left to match inside it, but still
+			// selects the directory when it is empty.
+			if c.named(childSegs) {
+				empty, err := isEmptyDir(childAbs)
+				if err != nil {
+					return err
+				}
+				if empty {
+					if err := c.consider(childSegs, true); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if c.explain && reason != "" {
+				c.recordExcluded(childSegs, true, reason)
+			}
+			continue
+		}
+		if err := c.consider(childSegs, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipped applies rules that hold for every target: .pmignore files and the
+// spec-wide exclude list. Ancestors were already checked on the way down. The
+// returned reason names the responsible rule and is empty if segs is kept.
+func (c *collector) skipped(segs []string, isDir bool) (string, error) {
+	if g := c.exclude.decide(segs); g != nil && !g.negate {
+		return "exclude: " + g.source, nil
+	}
+	rule, err := c.ignore.match(segs, isDir)
+	if err != nil || rule == nil || rule.negate {
+		return "", err
+	}
+	return rule.source, nil
+}
+
+// shouldDescend reports whether any target may select something below segs.
+// If targets would but their exclude lists prevent it, the reason says which.
+func (c *collector) shouldDescend(segs []string) (bool, string) {
+	reason := ""
+	for _, target := range c.targets {
+		if !target.patterns.mayContain(segs) {
+			continue
+		}
+		ex := target.exclude.decide(segs)
+		if ex == nil || ex.negate {
+			return true, ""
+		}
+		if reason == "" {
+			reason = fmt.Sprintf("target %s exclude: %s", target.spec, ex.source)
+		}
+	}
+	return false, reason
+}
+
+// named reports whether a pattern of any target matches segs itself.
+func (c *collector) named(segs []string) bool {
+	for _, target := range c.targets {
+		if target.patterns.decide(segs) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmptyDir reads at most one entry of a directory, so that a large one
+// that is not descended into costs little.
+func isEmptyDir(abs string) (bool, error) {
+	f, err := os.Open(abs)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err := f.Readdirnames(1); err != nil {
+		if errors.Is(err, io.EOF) {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// wanted reports whether a path dropped by a global rule would otherwise have
+// been considered, so explanations only mention paths that matter.
+func (c *collector) wanted(segs []string, isDir bool) bool {
+	if isDir {
+		if descend, _ := c.shouldDescend(segs); descend {
+			return true
+		}
+	}
+	for _, target := range c.targets {
+		if g, _ := target.evaluate(segs); g != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *collector) recordExcluded(segs []string, isDir bool, reason string) {
+	c.excluded = append(c.excluded, ExcludedPath{Path: strings.Join(segs, "/"), Dir: isDir, Rule: reason})
+}
+
+func (c *collector) consider(segs []string, isDir bool) error {
+	rel := strings.Join(segs, "/")
+	reason := ""
+	for i, target := range c.targets {
+		g, why := target.evaluate(segs)
+		if g == nil {
+			if reason == "" {
+				reason = why
+			}
+			continue
+		}
+		archivePath, err := mapTargetPath(target.spec, rel)
+		if err != nil {
+			return err
+		}
+		if other, exists := c.owners[archivePath]; exists {
+			return fmt.Errorf("%s and %s both map to %s in the archive", other, rel, archivePath)
+		}
+		c.owners[archivePath] = rel
+		c.claimed[i]++
+		c.files = append(c.files, fileRef{
+			Source:  rel,
+			Path:    archivePath,
+			Mode:    target.spec.Mode,
+			Target:  target.spec.String(),
+			Pattern: g.source,
+		})
+		return nil
+	}
+	if c.explain && reason != "" {
+		c.recordExcluded(segs, isDir, reason)
+	}
+	return nil
+}
+
+func mapTargetPath(target config.TargetSpec, rel string) (string, error) {
+	mapped := rel
+	if target.StripPrefix != "" {
+		prefix := path.Clean(strings.TrimPrefix(filepath.ToSlash(target.StripPrefix), "./"))
+		switch {
+		case prefix == ".":
+		case mapped == prefix:
+			mapped = ""
+		case strings.HasPrefix(mapped, prefix+"/"):
+			mapped = strings.TrimPrefix(mapped, prefix+"/")
+		default:
+			return "", fmt.Errorf("%s matched by %s does not start with strip_prefix %s", rel, target, target.StripPrefix)
+		}
+	}
+	if target.Dest != "" {
+		mapped = path.Join(filepath.ToSlash(target.Dest), mapped)
+	}
+	if mapped == "" || mapped == "." {
+		return "", fmt.Errorf("%s matched by %s maps to an empty archive path", rel, target)
+	}
+	if strings.HasPrefix(mapped, "/") || !filepath.IsLocal(filepath.FromSlash(mapped)) {
+		return "", fmt.Errorf("%s matched by %s maps to %s outside the package root", rel, target, mapped)
+	}
+	return path.Clean(mapped), nil
+}
@@ -0,0 +1,301 @@
+package packager
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"pm/internal/config"
+)
+
+func TestCollectEmptyDirectories(t *testing.T) {
+	tests := []struct {
+		name   string
+		target config.TargetSpec
+		want   []string
+	}{
+		{name: "named", target: targetSpec("empty"), want: []string{"empty"}},
+		{name: "star", target: targetSpec("*"), want: []string{"empty", "top.txt"}},
+		{name: "contents", target: targetSpec("empty/**"), want: []string{"empty"}},
+		{name: "subdirectory", target: targetSpec("dir/*"), want: []string{"dir/file", "dir/sub"}},
+		{name: "double star", target: targetSpec("dir/**"), want: []string{"dir/file", "dir/full/x", "dir/sub"}},
+		{name: "brace", target: targetSpec("{empty,dir/sub}"), want: []string{"dir/sub", "empty"}},
+		{name: "excluded", target: config.TargetSpec{Patterns: []string{"dir/*"}, Exclude: []string{"sub"}}, want: []string{"dir/file"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, map[string]string{
+				"empty/":     "",
+				"top.txt":    "x",
+				"dir/file":   "x",
+				"dir/sub/":   "",
+				"dir/full/x": "x",
+			})
+			spec := &config.PackageSpec{Targets: []config.TargetSpec{tt.target}}
+			c, err := collectFiles(spec, root, false, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, f := range c.files {
+				got = append(got, f.Source)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if unmatched := c.unmatched(); len(unmatched) > 0 {
+				t.Errorf("unmatched targets %v", unmatched)
+			}
+		})
+	}
+}
+
+func TestDryRunListsExcludedEmptyDirectory(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"dir/file": "x", "dir/sub/": ""})
+	spec := &config.PackageSpec{Targets: []config.TargetSpec{{Patterns: []string{"dir/*"}, Exclude: []string{"sub"}}}}
+	c, err := collectFiles(spec, root, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ExcludedPath{{Path: "dir/sub", Dir: true, Rule: "target dir/* exclude: sub"}}
+	if !reflect.DeepEqual(c.excluded, want) {
+		t.Errorf("got %+v, want %+v", c.excluded, want)
+	}
+}
+
+// benchmarkTree creates about 100k files, most of them in node_modules and
+// .git, which no target asks for.
+func benchmarkTree(b *testing.B) string {
+	b.Helper()
+	root := b.TempDir()
+	files := map[string]string{}
+	for i := 0; i < 800; i++ {
+		for j := 0; j < 100; j++ {
+			files[fmt.Sprintf("node_modules/pkg%03d/lib/file%02d.js", i, j)] = ""
+		}
+	}
+	for i := 0; i < 256; i++ {
+		for j := 0; j < 60; j++ {
+			files[fmt.Sprintf(".git/objects/%02x/%038d", i, j)] = ""
+		}
+	}
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 45; j++ {
+			files[fmt.Sprintf("src/mod%02d/file%02d.go", i, j)] = ""
+		}
+	}
+	for i := 0; i < 100; i++ {
+		files[fmt.Sprintf("bin/tool%02d", i)] = ""
+		files[fmt.Sprintf("config/app%02d.yaml", i)] = ""
+	}
+	writeFiles(b, root, files)
+	return root
+}
+
+// collectPerTarget is how files were collected before the single walk: the
+// whole tree is walked once per target and every path is matched.
+func collectPerTarget(spec *config.PackageSpec, root string) ([]string, error) {
+	seen := map[string]bool{}
+	var files []string
+	for _, target := range spec.Targets {
+		compiled, err := compileTarget(spec, root, target)
+		if err != nil {
+			return nil, err
+		}
+		err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, _ := filepath.Rel(root, p)
+			rel = filepath.ToSlash(rel)
+			if g, _ := compiled.evaluate(strings.Split(rel, "/")); g != nil && !seen[rel] {
+				seen[rel] = true
+				files = append(files, rel)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func BenchmarkCollectFiles(b *testing.B) {
+	root := benchmarkTree(b)
+	spec := &config.PackageSpec{Targets: []config.TargetSpec{
+		targetSpec("src/**/*.go"),
+		targetSpec("bin/*"),
+		targetSpec("config/*.yaml"),
+	}}
+	want := 100*45 + 200
+
+	b.Run("single-walk", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			c, err := collectFiles(spec, root, false, false)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(c.files) != want {
+				b.Fatalf("collected %d files, want %d", len(c.files), want)
+			}
+		}
+	})
+	b.Run("per-target-walk", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			files, err := collectPerTarget(spec, root)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if len(files) != want {
+				b.Fatalf("collected %d files, want %d", len(files), want)
+			}
+		}
+	})
+}
+
+func TestMapTargetPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		target config.TargetSpec
+		rel    string
+		want   string
+		err    string
+	}{
+		{name: "unchanged", target: targetSpec("bin/*"), rel: "bin/app", want: "bin/app"},
+		{name: "dest", target: config.TargetSpec{Patterns: []string{"app"}, Dest: "usr/bin"}, rel: "app", want: "usr/bin/app"},
+		{name: "dest with dots", target: config.TargetSpec{Patterns: []string{"app"}, Dest: "./opt/x/../bin/"}, rel: "app", want: "opt/bin/app"},
+		{name: "strip prefix", target: config.TargetSpec{Patterns: []string{"build/**"}, StripPrefix: "build"}, rel: "build/bin/app", want: "bin/app"},
+		{name: "strip prefix with slashes", target: config.TargetSpec{Patterns: []string{"build/**"}, StripPrefix: "./build/"}, rel: "build/bin/app", want: "bin/app"},
+		{name: "strip dot", target: config.TargetSpec{Patterns: []string{"bin/*"}, StripPrefix: "."}, rel: "bin/app", want: "bin/app"},
+		{name: "strip and dest", target: config.TargetSpec{Patterns: []string{"out/*"}, StripPrefix: "out", Dest: "lib"}, rel: "out/a.so", want: "lib/a.so"},
+		{name: "strip whole path into dest", target: config.TargetSpec{Patterns: []string{"out/app"}, StripPrefix: "out/app", Dest: "bin"}, rel: "out/app", want: "bin"},
+		{
+			name:   "prefix mismatch",
+			target: config.TargetSpec{Patterns: []string{"*/app"}, StripPrefix: "build"},
+			rel:    "src/app",
+			err:    "src/app matched by */app does not start with strip_prefix build",
+		},
+		{
+			name:   "prefix of a name",
+			target: config.TargetSpec{Patterns: []string{"*/app"}, StripPrefix: "build"},
+			rel:    "buildx/app",
+			err:    "does not start with strip_prefix build",
+		},
+		{
+			name:   "empty path",
+			target: config.TargetSpec{Patterns: []string{"build"}, StripPrefix: "build"},
+			rel:    "build",
+			err:    "build matched by build maps to an empty archive path",
+		},
+		{
+			name:   "dest escapes",
+			target: config.TargetSpec{Patterns: []string{"app"}, Dest: "../outside"},
+			rel:    "app",
+			err:    "app matched by app maps to ../outside/app outside the package root",
+		},
+		{
+			name:   "dest climbs out",
+			target: config.TargetSpec{Patterns: []string{"app"}, Dest: "a/../.."},
+			rel:    "app",
+			err:    "outside the package root",
+		},
+		{
+			name:   "absolute dest",
+			target: config.TargetSpec{Patterns: []string{"app"}, Dest: "/usr/bin"},
+			rel:    "app",
+			err:    "maps to /usr/bin/app outside the package root",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mapTargetPath(tt.target, tt.rel)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %q, %v, want error %q", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectRejectsSameArchivePath(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"a/app": "a", "b/app": "b"})
+	spec := &config.PackageSpec{Targets: []config.TargetSpec{
+		{Patterns: []string{"a/app"}, StripPrefix: "a", Dest: "bin"},
+		{Patterns: []string{"b/app"}, StripPrefix: "b", Dest: "bin"},
+	}}
+	_, err := collectFiles(spec, root, false, false)
+	if err == nil || !strings.Contains(err.Error(), "a/app and b/app both map to bin/app in the archive") {
+		t.Errorf("got %v, want both files mapping to bin/app", err)
+	}
+}
+
+func TestUnsatisfiedTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []config.TargetSpec
+		want    []string
+	}{
+		{name: "optional and empty", targets: []config.TargetSpec{targetSpec("doc/*")}},
+		{name: "required and met", targets: []config.TargetSpec{{Patterns: []string{"bin/*"}, Required: true}}},
+		{name: "required matches nothing", targets: []config.TargetSpec{{Patterns: []string{"doc/*"}, Required: true}}, want: []string{"target doc/* matched 0 files, at least 1 required"}},
+		{name: "min_files met", targets: []config.TargetSpec{{Patterns: []string{"bin/*"}, MinFiles: 2}}},
+		{name: "min_files not met", targets: []config.TargetSpec{{Patterns: []string{"bin/*"}, MinFiles: 3}}, want: []string{"target bin/* matched 2 files, at least 3 required"}},
+		{name: "min_files raises required", targets: []config.TargetSpec{{Patterns: []string{"bin/*"}, Required: true, MinFiles: 3}}, want: []string{"target bin/* matched 2 files, at least 3 required"}},
+		{
+			// Files claimed by an earlier target do not count for a later one.
+			name:    "claimed by an earlier target",
+			targets: []config.TargetSpec{targetSpec("bin/*"), {Patterns: []string{"bin/a"}, Required: true}},
+			want:    []string{"target bin/a matched 0 files, at least 1 required"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, map[string]string{"bin/a": "a", "bin/b": "b"})
+			c, err := collectFiles(&config.PackageSpec{Targets: tt.targets}, root, false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.unsatisfied(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateFailsOnUnsatisfiedTargets(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"bin/a": "a"})
+	spec := &config.PackageSpec{Name: "app", Version: "1.0", Targets: []config.TargetSpec{
+		targetSpec("bin/*"),
+		{Patterns: []string{"lib/*"}, Required: true},
+		{Patterns: []string{"share/*", "bin/*"}, MinFiles: 2},
+	}}
+	out := filepath.Join(t.TempDir(), "app.tar.gz")
+	_, _, err := Create(spec, CreateOptions{WorkingDir: root, OutputPath: out})
+	want := "target lib/* matched 0 files, at least 1 required; target [share/*, bin/*] matched 0 files, at least 2 required"
+	if err == nil || err.Error() != want {
+		t.Fatalf("got %v, want %q", err, want)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("archive written despite the error: %v", err)
+	}
+}
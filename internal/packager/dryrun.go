@@ -0,0 +1,88 @@
+package packager
+
+import (
+	"io/fs"
+	"path/filepath"
+
+	"pm/internal/config"
+)
+
+type Plan struct {
+	Output      string         `json:"output"`
+	Format      string         `json:"format"`
+	Compression string         `json:"compression"`
+	Platform    string         `json:"platform"`
+	Files       []PlannedFile  `json:"files"`
+	Excluded    []ExcludedPath `json:"excluded"`
+	Unmatched   []string       `json:"unmatched_targets"`
+	Errors      []string       `json:"errors,omitempty"`
+}
+
+type PlannedFile struct {
+	Path    string `json:"path"`
+	Source  string `json:"source,omitempty"`
+	Type    string `json:"type"`
+	Size    int64  `json:"size"`
+	Target  string `json:"target"`
+	Pattern string `json:"pattern"`
+}
+
+type ExcludedPath struct {
+	Path string `json:"path"`
+	Dir  bool   `json:"dir,omitempty"`
+	Rule string `json:"rule"`
+}
+
+// DryRun resolves the spec exactly like Create but only reports what would be
+// packaged, what was excluded and by which rule. Nothing is written.
+func DryRun(spec *config.PackageSpec, opts CreateOptions) (*Plan, error) {
+	job, err := prepare(spec, opts, true)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		Output:      job.output,
+		Format:      job.settings.format.Name,
+		Compression: job.settings.compression.String(),
+		Platform:    job.platform.String(),
+		Files:       make([]PlannedFile, 0, len(job.files.files)),
+		Excluded:    job.files.excluded,
+		Unmatched:   job.files.unmatched(),
+		Errors:      job.files.unsatisfied(),
+	}
+	for _, file := range job.files.files {
+		info, err := job.settings.stat(filepath.Join(job.baseDir, file.Source))
+		if err != nil {
+			return nil, err
+		}
+		planned := PlannedFile{
+			Path:    file.Path,
+			Type:    EntryFile,
+			Size:    info.Size(),
+			Target:  file.Target,
+			Pattern: file.Pattern,
+		}
+		if file.Source != file.Path {
+			planned.Source = file.Source
+		}
+		switch {
+		case info.IsDir():
+			planned.Type = EntryDir
+			planned.Size = 0
+		case info.Mode()&fs.ModeSymlink != 0:
+			planned.Type = EntrySymlink
+			planned.Size = 0
+		}
+		plan.Files = append(plan.Files, planned)
+	}
+	return plan, nil
+}
+
+func (p *Plan) TotalSize() int64 {
+	var total int64
+	for _, f := range p.Files {
+		total += f.Size
+	}
+	return total
+}
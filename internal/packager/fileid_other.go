@@ -0,0 +1,9 @@
+//go:build !unix
+
+package packager
+
+import "io/fs"
+
+func fileID(info fs.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
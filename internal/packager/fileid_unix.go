@@ -0,0 +1,16 @@
+//go:build unix
+
+package packager
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+func fileID(info fs.FileInfo) (fileKey, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return fileKey{}, false
+	}
+	return fileKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
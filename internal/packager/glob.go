@@ -0,0 +1,229 @@
+package packager
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+type globOptions struct {
+	dotfiles   bool
+	ignoreCase bool
+}
+
+// glob is a compiled path pattern. Braces are expanded up front, so every
+// alternative is a plain list of path.Match segments plus "**".
+type glob struct {
+	source   string
+	alts     [][]string
+	negate   bool
+	basename bool
+	opts     globOptions
+}
+
+func compileGlob(pattern string, opts globOptions) (*glob, error) {
+	g := &glob{source: pattern, opts: opts}
+	for _, alt := range expandBraces(pattern) {
+		alt = strings.TrimPrefix(alt, "./")
+		if opts.ignoreCase {
+			alt = strings.ToLower(alt)
+		}
+		segs := strings.Split(alt, "/")
+		for _, seg := range segs {
+			if _, err := path.Match(seg, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q", pattern)
+			}
+		}
+		g.alts = append(g.alts, segs)
+	}
+	return g, nil
+}
+
+func (g *glob) match(segs []string) bool {
+	if g.basename && len(segs) > 0 {
+		segs = segs[len(segs)-1:]
+	}
+	for _, alt := range g.alts {
+		if g.matchSegments(alt, segs) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *glob) matchSegments(patternSegs, targetSegs []string) bool {
+	if len(patternSegs) == 0 {
+		return len(targetSegs) == 0
+	}
+
+	if patternSegs[0] == "**" {
+		if g.matchSegments(patternSegs[1:], targetSegs) {
+			return true
+		}
+		for i := 0; i < len(targetSegs); i++ {
+			if !g.matchWildcard(targetSegs[i]) {
+				return false
+			}
+			if g.matchSegments(patternSegs[1:], targetSegs[i+1:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(targetSegs) == 0 || !g.matchSegment(patternSegs[0], targetSegs[0]) {
+		return false
+	}
+	return g.matchSegments(patternSegs[1:], targetSegs[1:])
+}
+
+// mayContain reports whether the pattern could match something below dir.
+// Literal leading segments act as a static prefix, so a pattern like
+// "build/out/**" never descends into node_modules or .git.
+func (g *glob) mayContain(dir []string) bool {
+	for _, alt := range g.alts {
+		if g.matchPrefix(alt, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *glob) matchPrefix(patternSegs, dirSegs []string) bool {
+	if len(dirSegs) == 0 {
+		return len(patternSegs) > 0
+	}
+	if len(patternSegs) == 0 {
+		return false
+	}
+	if patternSegs[0] == "**" {
+		if g.matchPrefix(patternSegs[1:], dirSegs) {
+			return true
+		}
+		return g.matchWildcard(dirSegs[0]) && g.matchPrefix(patternSegs, dirSegs[1:])
+	}
+	if !g.matchSegment(patternSegs[0], dirSegs[0]) {
+		return false
+	}
+	return g.matchPrefix(patternSegs[1:], dirSegs[1:])
+}
+
+// matchWildcard reports whether "**" may swallow name.
+func (g *glob) matchWildcard(name string) bool {
+	return g.opts.dotfiles || !strings.HasPrefix(name, ".")
+}
+
+// matchSegment is path.Match with two shell conventions on top: a leading dot
+// has to be matched literally unless dotfiles are enabled, and case can be
+// ignored.
+func (g *glob) matchSegment(pattern, name string) bool {
+	if !g.opts.dotfiles && strings.HasPrefix(name, ".") && !strings.HasPrefix(pattern, ".") && !strings.HasPrefix(pattern, `\.`) {
+		return false
+	}
+	if g.opts.ignoreCase {
+		name = strings.ToLower(name)
+	}
+	ok, err := path.Match(pattern, name)
+	return err == nil && ok
+}
+
+// globList is an ordered pattern list in which entries prefixed with "!"
+// negate earlier ones; the last matching pattern wins.
+type globList []*glob
+
+func compileGlobList(patterns []string, opts globOptions, basename bool) (globList, error) {
+	var list globList
+	for _, pattern := range patterns {
+		negate := strings.HasPrefix(pattern, "!")
+		if negate {
+			pattern = pattern[1:]
+		}
+		if pattern == "" {
+			return nil, fmt.Errorf("empty pattern")
+		}
+		g, err := compileGlob(pattern, opts)
+		if err != nil {
+			return nil, err
+		}
+		g.negate = negate
+		if negate {
+			g.source = "!" + g.source
+		}
+		g.basename = basename && !strings.Contains(pattern, "/")
+		list = append(list, g)
+	}
+	return list, nil
+}
+
+func (l globList) match(segs []string) bool {
+	g := l.decide(segs)
+	return g != nil && !g.negate
+}
+
+// decide returns the last pattern matching segs, or nil if none does.
+func (l globList) decide(segs []string) *glob {
+	var last *glob
+	for _, g := range l {
+		if g.match(segs) {
+			last = g
+		}
+	}
+	return last
+}
+
+func (l globList) mayContain(dir []string) bool {
+	for _, g := range l {
+		if !g.negate && g.mayContain(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandBraces turns "a.{so,a}" into "a.so" and "a.a". Nested groups are
+// expanded recursively; a group without a comma or without a closing brace is
+// kept literally, as in the shell.
+func expandBraces(pattern string) []string {
+	open := -1
+	depth := 0
+	var commas []int
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			if depth == 0 {
+				open = i
+				commas = commas[:0]
+			}
+			depth++
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		case '}':
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+			if len(commas) == 0 {
+				open = -1
+				continue
+			}
+			prefix, suffix := pattern[:open], pattern[i+1:]
+			var out []string
+			start := open + 1
+			for _, end := range append(commas, i) {
+				for _, tail := range expandBraces(pattern[start:end] + suffix) {
+					out = append(out, prefix+tail)
+				}
+				start = end + 1
+			}
+			return out
+		}
+	}
+	return []string{pattern}
+}
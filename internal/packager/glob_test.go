@@ -0,0 +1,154 @@
+package packager
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"pm/internal/config"
+)
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"lib.so", []string{"lib.so"}},
+		{"lib.{so,a}", []string{"lib.so", "lib.a"}},
+		{"{bin,lib}/*", []string{"bin/*", "lib/*"}},
+		{"{a,b}{1,2}", []string{"a1", "a2", "b1", "b2"}},
+		{"x{a,{b,c}}y", []string{"xay", "xby", "xcy"}},
+		{"{a,b{1,2}}", []string{"a", "b1", "b2"}},
+		{"{,.min}.js", []string{".js", ".min.js"}},
+		{"{single}", []string{"{single}"}},
+		{"{single}{a,b}", []string{"{single}a", "{single}b"}},
+		{"{open,", []string{"{open,"}},
+		{"close}", []string{"close}"}},
+		{`\{a,b}`, []string{`\{a,b}`}},
+		{`{a\,b,c}`, []string{`a\,b`, "c"}},
+		{`{a\},b}`, []string{`a\}`, "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if got := expandBraces(tt.pattern); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGlobListDecide(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		opts     globOptions
+		basename bool
+		path     string
+		// want is the source of the deciding pattern, "" if none matches.
+		want string
+	}{
+		{name: "star", patterns: []string{"bin/*"}, path: "bin/tool", want: "bin/*"},
+		{name: "star is one segment", patterns: []string{"bin/*"}, path: "bin/sub/tool"},
+		{name: "double star", patterns: []string{"src/**/*.go"}, path: "src/a/b/c.go", want: "src/**/*.go"},
+		{name: "double star matches none", patterns: []string{"src/**/*.go"}, path: "src/c.go", want: "src/**/*.go"},
+		{name: "brace", patterns: []string{"lib/*.{so,a}"}, path: "lib/x.a", want: "lib/*.{so,a}"},
+		{name: "brace no match", patterns: []string{"lib/*.{so,a}"}, path: "lib/x.la"},
+		{name: "negation", patterns: []string{"bin/*", "!bin/*.debug"}, path: "bin/tool.debug", want: "!bin/*.debug"},
+		{name: "negation leaves others", patterns: []string{"bin/*", "!bin/*.debug"}, path: "bin/tool", want: "bin/*"},
+		{name: "later pattern re-includes", patterns: []string{"bin/*", "!bin/*.debug", "bin/keep.debug"}, path: "bin/keep.debug", want: "bin/keep.debug"},
+		{name: "dotfile excluded from star", patterns: []string{"*"}, path: ".env"},
+		{name: "dotfile excluded from double star", patterns: []string{"**"}, path: "a/.hidden/b"},
+		{name: "dotfile excluded from star suffix", patterns: []string{"conf/*rc"}, path: "conf/.bashrc"},
+		{name: "dotfile named literally", patterns: []string{".env"}, path: ".env", want: ".env"},
+		{name: "dotfile by leading dot", patterns: []string{"conf/.*"}, path: "conf/.bashrc", want: "conf/.*"},
+		{name: "dotfiles option star", patterns: []string{"*"}, opts: globOptions{dotfiles: true}, path: ".env", want: "*"},
+		{name: "dotfiles option double star", patterns: []string{"**"}, opts: globOptions{dotfiles: true}, path: "a/.hidden/b", want: "**"},
+		{name: "case sensitive", patterns: []string{"*.PNG"}, path: "a.png"},
+		{name: "ignore case", patterns: []string{"docs/*.PNG"}, opts: globOptions{ignoreCase: true}, path: "Docs/A.png", want: "docs/*.PNG"},
+		{name: "ignore case brace", patterns: []string{"*.{JPG,png}"}, opts: globOptions{ignoreCase: true}, path: "a.jpg", want: "*.{JPG,png}"},
+		{name: "leading dot slash", patterns: []string{"./bin/*"}, path: "bin/tool", want: "./bin/*"},
+		{name: "basename", patterns: []string{"*.tmp"}, basename: true, path: "a/b/c.tmp", want: "*.tmp"},
+		{name: "basename only without slash", patterns: []string{"b/*.tmp"}, basename: true, path: "a/b/c.tmp"},
+		{name: "escaped wildcard", patterns: []string{`a\*`}, path: "ab"},
+		{name: "escaped wildcard literal", patterns: []string{`a\*`}, path: "a*", want: `a\*`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := compileGlobList(tt.patterns, tt.opts, tt.basename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if g := list.decide(strings.Split(tt.path, "/")); g != nil {
+				got = g.source
+			}
+			if got != tt.want {
+				t.Errorf("%s: got %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileGlobListErrors(t *testing.T) {
+	for _, patterns := range [][]string{{"!"}, {""}, {"a/[b"}} {
+		if _, err := compileGlobList(patterns, globOptions{}, false); err == nil {
+			t.Errorf("%q: expected an error", patterns)
+		}
+	}
+}
+
+func TestGlobMayContain(t *testing.T) {
+	tests := []struct {
+		pattern string
+		dir     string
+		want    bool
+	}{
+		{"build/out/**", "build", true},
+		{"build/out/**", "build/out", true},
+		{"build/out/**", "node_modules", false},
+		{"src/**/*.go", "src/a/b", true},
+		{"**/*.go", "vendor", true},
+		{"**/*.go", ".git", false},
+		{"{src,lib}/*", "lib", true},
+		{"{src,lib}/*", "doc", false},
+		{"bin/*", "bin/sub", false},
+	}
+	for _, tt := range tests {
+		g, err := compileGlob(tt.pattern, globOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := g.mayContain(strings.Split(tt.dir, "/")); got != tt.want {
+			t.Errorf("%s in %s: got %v, want %v", tt.pattern, tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestCollectTargetOptions(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"etc/app.conf":   "x",
+		"etc/.secret":    "x",
+		"etc/debug.conf": "x",
+		"img/Logo.PNG":   "x",
+	})
+	tests := []struct {
+		name   string
+		target config.TargetSpec
+		want   []string
+	}{
+		{name: "default", target: targetSpec("etc/*"), want: []string{"etc/app.conf", "etc/debug.conf"}},
+		{name: "negated pattern", target: targetSpec("etc/*", "!etc/debug.*"), want: []string{"etc/app.conf"}},
+		{name: "dotfiles", target: config.TargetSpec{Patterns: []string{"etc/*"}, Dotfiles: true}, want: []string{"etc/.secret", "etc/app.conf", "etc/debug.conf"}},
+		{name: "case", target: targetSpec("img/*.png"), want: []string{}},
+		{name: "ignore case", target: config.TargetSpec{Patterns: []string{"img/*.png"}, IgnoreCase: true}, want: []string{"img/Logo.PNG"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &config.PackageSpec{Targets: []config.TargetSpec{tt.target}}
+			if got := collected(t, spec, root); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
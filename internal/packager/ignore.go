@@ -0,0 +1,153 @@
+package packager
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const ignoreFileName = ".pmignore"
+
+type ignoreRule struct {
+	source  string
+	glob    *glob
+	negate  bool
+	dirOnly bool
+	// inside is set for patterns ending in "/**", which match everything
+	// inside a directory but not the directory itself.
+	inside bool
+}
+
+// ignoreMatcher applies .pmignore files found in the working directory and
+// its subdirectories with gitignore semantics: rules in deeper files win over
+// shallower ones, the last matching rule wins, and nothing inside an ignored
+// directory can be re-included.
+type ignoreMatcher struct {
+	root  string
+	rules map[string][]ignoreRule
+}
+
+func newIgnoreMatcher(root string) *ignoreMatcher {
+	return &ignoreMatcher{root: root, rules: map[string][]ignoreRule{}}
+}
+
+// match returns the rule that decides whether segs is ignored, or nil if no
+// rule matches. Callers walk top-down and never ask about entries inside an
+// ignored directory.
+func (m *ignoreMatcher) match(segs []string, isDir bool) (*ignoreRule, error) {
+	var decided *ignoreRule
+	for depth := 0; depth < len(segs); depth++ {
+		dir := strings.Join(segs[:depth], "/")
+		rules, err := m.load(dir)
+		if err != nil {
+			return nil, err
+		}
+		for i := range rules {
+			if rules[i].matches(segs[depth:], isDir) {
+				decided = &rules[i]
+			}
+		}
+	}
+	return decided, nil
+}
+
+func (m *ignoreMatcher) load(dir string) ([]ignoreRule, error) {
+	if rules, ok := m.rules[dir]; ok {
+		return rules, nil
+	}
+	rules, err := readIgnoreFile(m.root, path.Join(dir, ignoreFileName))
+	if err != nil {
+		return nil, err
+	}
+	m.rules[dir] = rules
+	return rules, nil
+}
+
+func readIgnoreFile(root, name string) ([]ignoreRule, error) {
+	f, err := os.Open(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if rule, ok := parseIgnoreLine(scanner.Text()); ok {
+			rule.source = fmt.Sprintf("%s:%d: %s", name, line, strings.TrimSpace(scanner.Text()))
+			rules = append(rules, rule)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+func parseIgnoreLine(line string) (ignoreRule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	line = trimUnescapedTrailingSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	var rule ignoreRule
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+	if strings.HasSuffix(line, "/**") {
+		rule.inside = true
+		line = strings.TrimSuffix(line, "/**")
+	}
+	// .gitignore wildcards match dotfiles, so .pmignore does too.
+	g, err := compileGlob(line, globOptions{dotfiles: true})
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.glob = g
+	return rule, true
+}
+
+func trimUnescapedTrailingSpace(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-2] + " "
+	}
+	return line
+}
+
+func (r ignoreRule) matches(segs []string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.inside {
+		return r.glob.match(segs)
+	}
+	for n := len(segs) - 1; n > 0; n-- {
+		if r.glob.match(segs[:n]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+package packager
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"pm/internal/config"
+)
+
+// writeFiles creates files under root with the given contents; names ending
+// in "/" are created as empty directories.
+func writeFiles(t testing.TB, root string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(full, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// collected returns the source paths the spec selects under root.
+func collected(t testing.TB, spec *config.PackageSpec, root string) []string {
+	t.Helper()
+	c, err := collectFiles(spec, root, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := []string{}
+	for _, f := range c.files {
+		out = append(out, f.Source)
+	}
+	return out
+}
+
+func targetSpec(patterns ...string) config.TargetSpec {
+	return config.TargetSpec{Patterns: patterns}
+}
+
+func TestParseIgnoreLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		ok      bool
+		negate  bool
+		dirOnly bool
+		inside  bool
+		glob    string
+	}{
+		{line: "", ok: false},
+		{line: "   ", ok: false},
+		{line: "# comment", ok: false},
+		{line: "/", ok: false},
+		{line: "*.log", ok: true, glob: "**/*.log"},
+		{line: "*.log\r", ok: true, glob: "**/*.log"},
+		{line: "!keep.log", ok: true, negate: true, glob: "**/keep.log"},
+		{line: `\!bang`, ok: true, glob: "**/!bang"},
+		{line: `\#hash`, ok: true, glob: "**/#hash"},
+		{line: "build/", ok: true, dirOnly: true, glob: "**/build"},
+		{line: "/foo", ok: true, glob: "foo"},
+		{line: "doc/*.md", ok: true, glob: "doc/*.md"},
+		{line: "logs/**", ok: true, inside: true, glob: "logs"},
+		{line: "tmp.txt   ", ok: true, glob: "**/tmp.txt"},
+		{line: `space\ `, ok: true, glob: "**/space "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			rule, ok := parseIgnoreLine(tt.line)
+			if ok != tt.ok {
+				t.Fatalf("got ok %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if rule.negate != tt.negate || rule.dirOnly != tt.dirOnly || rule.inside != tt.inside || rule.glob.source != tt.glob {
+				t.Errorf("got negate=%v dirOnly=%v inside=%v glob=%q, want negate=%v dirOnly=%v inside=%v glob=%q",
+					rule.negate, rule.dirOnly, rule.inside, rule.glob.source, tt.negate, tt.dirOnly, tt.inside, tt.glob)
+			}
+		})
+	}
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		path    string
+		dir     bool
+		ignored bool
+		rule    string
+	}{
+		{name: "basename anywhere", files: map[string]string{".pmignore": "*.log\n"}, path: "a/b/c.log", ignored: true, rule: ".pmignore:1: *.log"},
+		{name: "no match", files: map[string]string{".pmignore": "*.log\n"}, path: "a/c.txt"},
+		{name: "wildcards match dotfiles", files: map[string]string{".pmignore": "*.bak\n"}, path: ".env.bak", ignored: true},
+		{name: "negation", files: map[string]string{".pmignore": "*.log\n!keep.log\n"}, path: "keep.log", rule: ".pmignore:2: !keep.log"},
+		{name: "last rule wins", files: map[string]string{".pmignore": "!keep.log\n*.log\n"}, path: "keep.log", ignored: true, rule: ".pmignore:2: *.log"},
+		{name: "dir only matches directory", files: map[string]string{".pmignore": "build/\n"}, path: "src/build", dir: true, ignored: true},
+		{name: "dir only skips file", files: map[string]string{".pmignore": "build/\n"}, path: "build"},
+		{name: "anchored", files: map[string]string{".pmignore": "/foo\n"}, path: "foo", ignored: true},
+		{name: "anchored not nested", files: map[string]string{".pmignore": "/foo\n"}, path: "sub/foo"},
+		{name: "slash anchors", files: map[string]string{".pmignore": "doc/*.md\n"}, path: "x/doc/a.md"},
+		{name: "double star prefix", files: map[string]string{".pmignore": "**/tmp\n"}, path: "a/b/tmp", dir: true, ignored: true},
+		{name: "double star middle", files: map[string]string{".pmignore": "a/**/b\n"}, path: "a/x/y/b", ignored: true},
+		{name: "double star middle empty", files: map[string]string{".pmignore": "a/**/b\n"}, path: "a/b", ignored: true},
+		{name: "inside matches contents", files: map[string]string{".pmignore": "logs/**\n"}, path: "logs/a", ignored: true},
+		{name: "inside skips directory", files: map[string]string{".pmignore": "logs/**\n"}, path: "logs", dir: true},
+		{name: "escaped hash", files: map[string]string{".pmignore": "\\#hash\n"}, path: "#hash", ignored: true},
+		{name: "escaped trailing space", files: map[string]string{".pmignore": "space\\ \n"}, path: "space ", ignored: true},
+		{
+			name:  "nested file wins",
+			files: map[string]string{".pmignore": "*.log\n", "sub/.pmignore": "!keep.log\n"},
+			path:  "sub/keep.log", rule: "sub/.pmignore:1: !keep.log",
+		},
+		{
+			name:  "nested file only applies below it",
+			files: map[string]string{".pmignore": "*.log\n", "sub/.pmignore": "!keep.log\n"},
+			path:  "keep.log", ignored: true,
+		},
+		{
+			name:  "deeper file overrides root negation",
+			files: map[string]string{".pmignore": "!*.tmp\n", "sub/.pmignore": "*.tmp\n"},
+			path:  "sub/a.tmp", ignored: true, rule: "sub/.pmignore:1: *.tmp",
+		},
+		{
+			name:  "nested anchored is relative to its file",
+			files: map[string]string{"sub/.pmignore": "/only\n"},
+			path:  "sub/only", ignored: true,
+		},
+		{
+			name:  "nested anchored not at root",
+			files: map[string]string{"sub/.pmignore": "/only\n"},
+			path:  "only",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeFiles(t, root, tt.files)
+			rule, err := newIgnoreMatcher(root).match(strings.Split(tt.path, "/"), tt.dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ignored := rule != nil && !rule.negate; ignored != tt.ignored {
+				t.Errorf("%s: got ignored %v, want %v", tt.path, ignored, tt.ignored)
+			}
+			if tt.rule != "" && (rule == nil || rule.source != tt.rule) {
+				t.Errorf("%s: got rule %v, want %q", tt.path, rule, tt.rule)
+			}
+		})
+	}
+}
+
+func TestIgnoredDirectoryCannotBeReincluded(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		".pmignore":        "build/\n!build/keep.txt\n*.tmp\n",
+		"build/keep.txt":   "x",
+		"build/out.bin":    "x",
+		"src/main.go":      "x",
+		"src/scratch.tmp":  "x",
+		"src/.pmignore":    "!scratch.tmp\n",
+		"docs/notes.tmp":   "x",
+		"docs/readme.md":   "x",
+		"docs/.pmignore":   "readme.md\n",
+		"docs/sub/note.md": "x",
+	})
+	spec := &config.PackageSpec{Targets: []config.TargetSpec{targetSpec("**")}, Dotfiles: true}
+	want := []string{".pmignore", "docs/.pmignore", "docs/sub/note.md", "src/.pmignore", "src/main.go", "src/scratch.tmp"}
+	if got := collected(t, spec, root); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSpecExclude(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"bin/tool":       "x",
+		"bin/tool.debug": "x",
+		"lib/a.so":       "x",
+		"lib/test/b.so":  "x",
+	})
+	spec := &config.PackageSpec{
+		Targets: []config.TargetSpec{targetSpec("bin/*"), targetSpec("lib/**")},
+		Exclude: config.StringList{"*.debug", "lib/test"},
+	}
+	want := []string{"bin/tool", "lib/a.so"}
+	if got := collected(t, spec, root); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
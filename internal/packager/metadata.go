@@ -0,0 +1,67 @@
+package packager
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pm/internal/config"
+)
+
+// MetaDir is where a package keeps its lifecycle scripts and README, both
+// inside the archive and in the install directory, so remove can still find
+// them.
+func MetaDir(name string) string {
+	return path.Join(".pm", name)
+}
+
+// addReadme adds the spec's README to the collected files and returns its
+// path in the archive.
+func addReadme(spec *config.PackageSpec, c *collector) (string, error) {
+	if spec.Readme == "" {
+		return "", nil
+	}
+	archivePath := path.Join(MetaDir(spec.Name), path.Base(filepath.ToSlash(spec.Readme)))
+	if err := addMetaFile(spec, c, "readme", spec.Readme, archivePath, 0o644); err != nil {
+		return "", err
+	}
+	return archivePath, nil
+}
+
+// addMetaFile adds a file named by the spec field to the package's meta
+// directory, outside of any target.
+func addMetaFile(spec *config.PackageSpec, c *collector, field, name, archivePath string, mode fs.FileMode) error {
+	if spec.Name == "." || spec.Name == ".." || strings.ContainsAny(spec.Name, `/\`) {
+		return fmt.Errorf("package name %q cannot be used as a directory for %s", spec.Name, field)
+	}
+	source := filepath.ToSlash(filepath.Clean(name))
+	if !filepath.IsLocal(filepath.FromSlash(source)) {
+		return fmt.Errorf("%s: %s is not inside the working directory", field, name)
+	}
+	info, err := os.Lstat(filepath.Join(c.baseDir, filepath.FromSlash(source)))
+	if err != nil {
+		return fmt.Errorf("%s: %w", field, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s: %s is not a regular file", field, source)
+	}
+
+	if other, exists := c.owners[archivePath]; exists {
+		return fmt.Errorf("%s and %s both map to %s in the archive", other, field, archivePath)
+	}
+	c.owners[archivePath] = source
+	target, _, _ := strings.Cut(field, ".")
+	c.files = append(c.files, fileRef{
+		Source:  source,
+		Path:    archivePath,
+		Mode:    mode,
+		Target:  target,
+		Pattern: field,
+	})
+	sort.Slice(c.files, func(i, j int) bool { return c.files[i].Path < c.files[j].Path })
+	return nil
+}
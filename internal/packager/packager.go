@@ -1,186 +1,255 @@
 package packager
 
 import (
-	"archive/tar"
-	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
-	"path"
 	"path/filepath"
-	"sort"
 	"strings"
 	"time"
 
+	"pm/internal/archive"
 	"pm/internal/config"
 )
 
 type Manifest struct {
 	Name         string                  `json:"name"`
 	Version      string                  `json:"version"`
+	Description  string                  `json:"description,omitempty"`
+	License      string                  `json:"license,omitempty"`
+	Maintainers  []string                `json:"maintainers,omitempty"`
+	Homepage     string                  `json:"homepage,omitempty"`
+	Tags         []string                `json:"tags,omitempty"`
+	Readme       string                  `json:"readme,omitempty"`
+	OS           string                  `json:"os,omitempty"`
+	Arch         string                  `json:"arch,omitempty"`
 	CreatedAt    time.Time               `json:"created_at"`
 	Dependencies []config.DependencySpec `json:"dependencies"`
-	Files        []string                `json:"files"`
+	Conflicts    []config.DependencySpec `json:"conflicts,omitempty"`
+	Provides     []config.DependencySpec `json:"provides,omitempty"`
+	Replaces     []config.DependencySpec `json:"replaces,omitempty"`
+	Compression  string                  `json:"compression"`
+	Scripts      map[string]string       `json:"scripts,omitempty"`
+	Files        []FileEntry             `json:"files"`
+}
+
+type FileEntry struct {
+	Path   string `json:"path"`
+	Source string `json:"source,omitempty"`
+	Type   string `json:"type"`
+	Link   string `json:"link,omitempty"`
+	Size   int64  `json:"size"`
+	Mode   int64  `json:"mode"`
+	SHA256 string `json:"sha256"`
+}
+
+const (
+	EntryFile     = "file"
+	EntryDir      = "dir"
+	EntrySymlink  = "symlink"
+	EntryHardlink = "hardlink"
+)
+
+type fileRef struct {
+	Source  string
+	Path    string
+	Mode    fs.FileMode
+	Target  string
+	Pattern string
+}
+
+type fileKey struct {
+	dev uint64
+	ino uint64
 }
 
 type CreateOptions struct {
-	WorkingDir string
-	OutputPath string
+	WorkingDir     string
+	OutputPath     string
+	Format         string
+	Compression    string
+	Reproducible   bool
+	SourceDate     time.Time
+	FollowSymlinks bool
+	// OutputDir holds the archive when OutputPath is empty; it defaults to
+	// WorkingDir.
+	OutputDir string
+	// Platform overrides the spec's os/arch, e.g. "linux/arm64".
+	Platform string
+	// Warnf, if set, receives warnings such as targets that matched nothing.
+	Warnf func(format string, args ...any)
 }
 
 func Create(spec *config.PackageSpec, opts CreateOptions) (string, *Manifest, error) {
-	if opts.WorkingDir == "" {
-		cwd, err := os.Getwd()
-		if err != nil {
-			return "", nil, err
-		}
-		opts.WorkingDir = cwd
-	}
-
-	files, err := collectFiles(spec, opts.WorkingDir)
+	job, err := prepare(spec, opts, false)
 	if err != nil {
 		return "", nil, err
 	}
-
-	output := opts.OutputPath
-	if output == "" {
-		filename := fmt.Sprintf("%s-%s.tar.gz", spec.Name, spec.Version)
-		output = filepath.Join(opts.WorkingDir, filename)
+	if problems := job.files.unsatisfied(); len(problems) > 0 {
+		return "", nil, errors.New(strings.Join(problems, "; "))
 	}
-
-	if err := writeArchive(output, opts.WorkingDir, files, spec); err != nil {
-		return "", nil, err
+	if opts.Warnf != nil {
+		for _, target := range job.files.unmatched() {
+			opts.Warnf("target %s matched no files", target)
+		}
+	}
+	if len(job.files.files) == 0 {
+		return "", nil, fmt.Errorf("no files matched targets %s; run create --dry-run to see what was excluded", strings.Join(job.files.unmatched(), ", "))
 	}
 
+	createdAt := time.Now().UTC()
+	if opts.Reproducible {
+		createdAt = opts.SourceDate.UTC()
+	}
 	manifest := &Manifest{
 		Name:         spec.Name,
 		Version:      spec.Version,
-		CreatedAt:    time.Now().UTC(),
+		Description:  spec.Description,
+		License:      job.license,
+		Maintainers:  spec.Maintainers,
+		Homepage:     spec.Homepage,
+		Tags:         spec.Tags,
+		Readme:       job.readme,
+		OS:           job.platform.OS,
+		Arch:         job.platform.Arch,
+		CreatedAt:    createdAt,
 		Dependencies: spec.Packages,
-		Files:        files,
+		Conflicts:    spec.Conflicts,
+		Provides:     spec.Provides,
+		Replaces:     spec.Replaces,
+		Compression:  job.settings.compression.String(),
+		Scripts:      job.scripts,
 	}
 
-	return output, manifest, nil
+	if err := writeArchive(job.output, job.settings, job.baseDir, job.files.files, manifest); err != nil {
+		return "", nil, err
+	}
+	return job.output, manifest, nil
 }
 
-func collectFiles(spec *config.PackageSpec, baseDir string) ([]string, error) {
-	seen := map[string]struct{}{}
-	var files []string
+// createJob is everything Create and DryRun work out before touching the
+// output: the selected files, where the archive goes and how it is written.
+type createJob struct {
+	baseDir  string
+	output   string
+	files    *collector
+	scripts  map[string]string
+	readme   string
+	license  string
+	platform config.Platform
+	settings archiveSettings
+}
 
-	for _, target := range spec.Targets {
-		matches, err := globMatches(baseDir, target.Pattern)
+func prepare(spec *config.PackageSpec, opts CreateOptions, explain bool) (*createJob, error) {
+	if opts.WorkingDir == "" {
+		cwd, err := os.Getwd()
 		if err != nil {
 			return nil, err
 		}
-		excludes := target.Exclude
+		opts.WorkingDir = cwd
+	}
 
-		for _, match := range matches {
-			if shouldExclude(match, excludes) {
-				continue
-			}
-			if _, exists := seen[match]; exists {
-				continue
-			}
-			seen[match] = struct{}{}
-			files = append(files, match)
+	platform, err := spec.Platform()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Platform != "" {
+		if platform, err = config.ParsePlatform(opts.Platform); err != nil {
+			return nil, err
 		}
 	}
 
-	sort.Strings(files)
-	if len(files) == 0 {
-		return nil, fmt.Errorf("no files matched targets")
+	follow := opts.FollowSymlinks || spec.FollowSymlinks
+	files, err := collectFiles(spec, opts.WorkingDir, follow, explain)
+	if err != nil {
+		return nil, err
 	}
-	return files, nil
-}
-
-func shouldExclude(relPath string, patterns []string) bool {
-	for _, pattern := range patterns {
-		if strings.Contains(pattern, "/") {
-			if matchPattern(pattern, relPath) {
-				return true
-			}
-			continue
-		}
-		if ok, err := path.Match(pattern, filepath.Base(relPath)); err == nil && ok {
-			return true
+	scripts, err := addScripts(spec, files)
+	if err != nil {
+		return nil, err
+	}
+	readme, err := addReadme(spec, files)
+	if err != nil {
+		return nil, err
+	}
+	license := spec.License
+	if license != "" {
+		if license, err = config.NormalizeLicense(license); err != nil {
+			return nil, err
 		}
 	}
-	return false
-}
 
-func globMatches(baseDir, pattern string) ([]string, error) {
-	cleaned := strings.TrimPrefix(pattern, "./")
-	cleaned = strings.TrimPrefix(cleaned, baseDir+"/")
-	cleaned = filepath.ToSlash(cleaned)
-
-	var matches []string
-	err := filepath.WalkDir(baseDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if d.IsDir() {
-			return nil
-		}
-		rel, err := filepath.Rel(baseDir, path)
-		if err != nil {
-			return err
-		}
-		rel = filepath.ToSlash(rel)
-		if matchPattern(cleaned, rel) {
-			matches = append(matches, rel)
-		}
-		return nil
-	})
+	formatName := opts.Format
+	if formatName == "" {
+		formatName = spec.Format
+	}
+	compression := opts.Compression
+	if compression == "" {
+		compression = spec.Compression
+	}
+	format, comp, err := archive.Resolve(formatName, compression)
 	if err != nil {
 		return nil, err
 	}
-	return matches, nil
-}
 
-func matchPattern(pattern, target string) bool {
-	pattern = filepath.ToSlash(pattern)
-	target = filepath.ToSlash(target)
-
-	if pattern == "" {
-		return target == ""
+	output := opts.OutputPath
+	if output == "" {
+		dir := opts.OutputDir
+		if dir == "" {
+			dir = opts.WorkingDir
+		}
+		filename := fmt.Sprintf("%s-%s%s%s", spec.Name, spec.Version, platform.Suffix(), format.Extension)
+		output = filepath.Join(dir, filename)
 	}
 
-	pSegs := strings.Split(pattern, "/")
-	tSegs := strings.Split(target, "/")
-	return matchSegments(pSegs, tSegs)
+	return &createJob{
+		baseDir:  opts.WorkingDir,
+		output:   output,
+		files:    files,
+		scripts:  scripts,
+		readme:   readme,
+		license:  license,
+		platform: platform,
+		settings: archiveSettings{
+			format:       format,
+			compression:  comp,
+			reproducible: opts.Reproducible,
+			follow:       follow,
+		},
+	}, nil
 }
 
-func matchSegments(patternSegs, targetSegs []string) bool {
-	if len(patternSegs) == 0 {
-		return len(targetSegs) == 0
-	}
-
-	if patternSegs[0] == "**" {
-		if matchSegments(patternSegs[1:], targetSegs) {
-			return true
-		}
-		for i := 0; i < len(targetSegs); i++ {
-			if matchSegments(patternSegs, targetSegs[i+1:]) {
-				return true
-			}
-		}
-		return false
-	}
+type archiveSettings struct {
+	format       *archive.Format
+	compression  archive.Compression
+	reproducible bool
+	follow       bool
+}
 
-	if len(targetSegs) == 0 {
-		return false
+func (s archiveSettings) stat(name string) (fs.FileInfo, error) {
+	if s.follow {
+		return os.Stat(name)
 	}
+	return os.Lstat(name)
+}
 
-	ok, err := path.Match(patternSegs[0], targetSegs[0])
-	if err != nil || !ok {
-		return false
+func writeArchive(output string, settings archiveSettings, baseDir string, files []fileRef, manifest *Manifest) error {
+	links := map[fileKey]string{}
+	entries := make([]FileEntry, 0, len(files))
+	for _, file := range files {
+		entry, err := describeFile(baseDir, file, settings, links)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
 	}
-	return matchSegments(patternSegs[1:], targetSegs[1:])
-}
+	manifest.Files = entries
 
-func writeArchive(output, baseDir string, files []string, spec *config.PackageSpec) error {
 	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
 		return err
 	}
@@ -191,74 +260,189 @@ func writeArchive(output, baseDir string, files []string, spec *config.PackageSp
 	}
 	defer f.Close()
 
-	gz := gzip.NewWriter(f)
-	defer gz.Close()
-
-	tw := tar.NewWriter(gz)
-	defer tw.Close()
-
-	manifest := Manifest{
-		Name:         spec.Name,
-		Version:      spec.Version,
-		CreatedAt:    time.Now().UTC(),
-		Dependencies: spec.Packages,
-		Files:        files,
+	aw, err := settings.format.NewWriter(f, settings.compression)
+	if err != nil {
+		return err
 	}
+	defer aw.Close()
 
 	manifestData, err := json.MarshalIndent(manifest, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	if err := addFile(tw, "manifest.json", manifestData, 0o644); err != nil {
+	if err := addFile(aw, "manifest.json", manifestData, 0o644, manifest.CreatedAt); err != nil {
 		return err
 	}
 
-	for _, file := range files {
-		abs := filepath.Join(baseDir, file)
-		data, err := os.Open(abs)
-		if err != nil {
+	for _, entry := range entries {
+		if err := addEntry(aw, baseDir, entry, manifest.CreatedAt, settings); err != nil {
 			return err
 		}
+	}
 
-		info, err := data.Stat()
-		if err != nil {
-			data.Close()
-			return err
-		}
+	if err := aw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func describeFile(baseDir string, file fileRef, settings archiveSettings, links map[fileKey]string) (FileEntry, error) {
+	abs := filepath.Join(baseDir, file.Source)
+	info, err := settings.stat(abs)
+	if err != nil {
+		return FileEntry{}, err
+	}
 
-		header, err := tar.FileInfoHeader(info, "")
+	mode := info.Mode().Perm()
+	entry := FileEntry{Path: file.Path}
+	if file.Source != file.Path {
+		entry.Source = file.Source
+	}
+	switch {
+	case info.IsDir():
+		entry.Type = EntryDir
+		if settings.reproducible {
+			mode = 0o755
+		}
+		entry.Mode = int64(mode)
+		return entry, nil
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(abs)
 		if err != nil {
-			data.Close()
-			return err
+			return FileEntry{}, err
 		}
-		header.Name = file
+		entry.Type = EntrySymlink
+		entry.Link = filepath.ToSlash(target)
+		entry.Mode = 0o777
+		return entry, nil
+	case !info.Mode().IsRegular():
+		return FileEntry{}, fmt.Errorf("%s is not a regular file, directory or symlink", file.Source)
+	}
 
-		if err := tw.WriteHeader(header); err != nil {
-			data.Close()
-			return err
+	switch {
+	case file.Mode != 0:
+		mode = file.Mode
+	case settings.reproducible:
+		mode = normalizeMode(mode)
+	}
+	entry.Mode = int64(mode)
+
+	if settings.format.Hardlinks {
+		if key, ok := fileID(info); ok {
+			if first, seen := links[key]; seen {
+				entry.Type = EntryHardlink
+				entry.Link = first
+				return entry, nil
+			}
+			links[key] = file.Path
 		}
+	}
 
-		if _, err := io.Copy(tw, data); err != nil {
-			data.Close()
-			return err
-		}
-		data.Close()
+	data, err := os.Open(abs)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	defer data.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, data)
+	if err != nil {
+		return FileEntry{}, err
+	}
+	entry.Type = EntryFile
+	entry.Size = size
+	entry.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return entry, nil
+}
+
+func (e FileEntry) sourcePath() string {
+	if e.Source != "" {
+		return e.Source
+	}
+	return e.Path
+}
+
+func normalizeMode(mode fs.FileMode) fs.FileMode {
+	if mode&0o111 != 0 {
+		return 0o755
+	}
+	return 0o644
+}
+
+func addEntry(aw archive.Writer, baseDir string, entry FileEntry, modTime time.Time, settings archiveSettings) error {
+	abs := filepath.Join(baseDir, entry.sourcePath())
+	info, err := settings.stat(abs)
+	if err != nil {
+		return err
+	}
+
+	header, err := archive.FileInfoHeader(info, entry.Link)
+	if err != nil {
+		return err
+	}
+	header.Name = entry.Path
+	header.Mode = entry.Mode
+	switch entry.Type {
+	case EntryDir:
+		header.Type = archive.TypeDir
+	case EntrySymlink:
+		header.Type = archive.TypeSymlink
+		header.Linkname = entry.Link
+	case EntryHardlink:
+		header.Type = archive.TypeHardlink
+		header.Linkname = entry.Link
+		header.Size = 0
+	default:
+		header.Type = archive.TypeFile
+		header.Size = entry.Size
+	}
+	if settings.reproducible {
+		normalizeHeader(header, modTime)
+	}
+
+	if err := aw.WriteHeader(header); err != nil {
+		return err
+	}
+	if entry.Type != EntryFile {
+		return nil
 	}
 
+	data, err := os.Open(abs)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(aw, h), io.LimitReader(data, entry.Size)); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != entry.SHA256 {
+		return fmt.Errorf("file %s changed while it was being archived", entry.Path)
+	}
 	return nil
 }
 
-func addFile(tw *tar.Writer, name string, data []byte, mode int64) error {
-	header := &tar.Header{
+func normalizeHeader(header *archive.Header, modTime time.Time) {
+	header.Uid = 0
+	header.Gid = 0
+	header.Uname = ""
+	header.Gname = ""
+	header.ModTime = modTime
+}
+
+func addFile(aw archive.Writer, name string, data []byte, mode int64, modTime time.Time) error {
+	header := &archive.Header{
 		Name:    name,
+		Type:    archive.TypeFile,
 		Mode:    mode,
 		Size:    int64(len(data)),
-		ModTime: time.Now().UTC(),
+		ModTime: modTime,
 	}
-	if err := tw.WriteHeader(header); err != nil {
+	if err := aw.WriteHeader(header); err != nil {
 		return err
 	}
-	_, err := tw.Write(data)
+	_, err := aw.Write(data)
 	return err
 }
@@ -0,0 +1,103 @@
+package packager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"pm/internal/config"
+)
+
+func fileHash(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// touchAll gives every file and directory under root the time t.
+func touchAll(tb testing.TB, root string, t time.Time) {
+	tb.Helper()
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.Mode()&os.ModeSymlink != 0 {
+			return err
+		}
+		return os.Chtimes(path, t, t)
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestReproducibleCreate(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"bin/tool":       "#!/bin/sh\n",
+		"share/doc/a.md": "docs",
+		"share/empty/":   "",
+		"etc/app.conf":   "",
+	})
+	if err := os.Chmod(filepath.Join(root, "bin", "tool"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("tool", filepath.Join(root, "bin", "alias")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(root, "share", "doc", "a.md"), filepath.Join(root, "share", "doc", "b.md")); err != nil {
+		t.Fatal(err)
+	}
+	spec := &config.PackageSpec{
+		Name:    "app",
+		Version: "1.0",
+		Targets: []config.TargetSpec{targetSpec("bin/*"), targetSpec("share/**"), targetSpec("etc/*")},
+	}
+
+	for _, format := range []string{"tar.gz", "tar.zst", "tar", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			out := t.TempDir()
+			build := func(name string, reproducible bool, mtime time.Time) string {
+				t.Helper()
+				touchAll(t, root, mtime)
+				path, _, err := Create(spec, CreateOptions{
+					WorkingDir:   root,
+					OutputPath:   filepath.Join(out, name),
+					Format:       format,
+					Reproducible: reproducible,
+					SourceDate:   time.Unix(epoch, 0),
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return fileHash(t, path)
+			}
+			first := build("a", true, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+			second := build("b", true, time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC))
+			if first != second {
+				t.Errorf("reproducible builds differ: %s and %s", first, second)
+			}
+			// Without --reproducible the mtimes and the build time end up
+			// in the archive, which shows the comparison can fail.
+			if plain := build("c", false, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)); plain == first {
+				t.Error("a plain build matches the reproducible one")
+			}
+		})
+	}
+}
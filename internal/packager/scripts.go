@@ -0,0 +1,33 @@
+package packager
+
+import (
+	"path"
+	"sort"
+
+	"pm/internal/config"
+)
+
+// addScripts adds the spec's lifecycle scripts to the collected files and
+// returns the hook table for the manifest.
+func addScripts(spec *config.PackageSpec, c *collector) (map[string]string, error) {
+	hooks := spec.Scripts.Hooks()
+	if len(hooks) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, 0, len(hooks))
+	for hook := range hooks {
+		names = append(names, hook)
+	}
+	sort.Strings(names)
+
+	table := map[string]string{}
+	for _, hook := range names {
+		archivePath := path.Join(MetaDir(spec.Name), hook)
+		if err := addMetaFile(spec, c, "scripts."+hook, hooks[hook], archivePath, 0o755); err != nil {
+			return nil, err
+		}
+		table[hook] = archivePath
+	}
+	return table, nil
+}
@@ -0,0 +1,323 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	SignatureExt = ".sig"
+
+	privateKeyExt = ".key"
+	publicKeyExt  = ".pub"
+	trustedDir    = "trusted"
+	sigAlgorithm  = "ed25519"
+	sigContext    = "pm-archive-v2\n"
+)
+
+var (
+	ErrUnsigned     = errors.New("archive is not signed")
+	ErrUntrustedKey = errors.New("untrusted key")
+)
+
+type PublicKey struct {
+	Name string
+	ID   string
+	Key  ed25519.PublicKey
+}
+
+type PrivateKey struct {
+	Name string
+	ID   string
+	Key  ed25519.PrivateKey
+}
+
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+func DefaultKeysDir() string {
+	if dir := os.Getenv("PM_KEYS_DIR"); dir != "" {
+		return dir
+	}
+	cfg, err := os.UserConfigDir()
+	if err != nil || cfg == "" {
+		return filepath.Join(".pm", "keys")
+	}
+	return filepath.Join(cfg, "pm", "keys")
+}
+
+func Generate(dir, name string) (*PrivateKey, error) {
+	if err := validateKeyName(name); err != nil {
+		return nil, err
+	}
+	privPath := filepath.Join(dir, name+privateKeyExt)
+	if _, err := os.Stat(privPath); err == nil {
+		return nil, fmt.Errorf("key %s already exists in %s", name, dir)
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	if err := writePEM(privPath, "PRIVATE KEY", privDER, 0o600); err != nil {
+		return nil, err
+	}
+	if err := writePublicKey(filepath.Join(dir, name+publicKeyExt), pub); err != nil {
+		return nil, err
+	}
+	return &PrivateKey{Name: name, ID: KeyID(pub), Key: priv}, nil
+}
+
+func Trust(dir, pubPath, name string) (*PublicKey, error) {
+	key, err := LoadPublicKey(pubPath)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(pubPath), filepath.Ext(pubPath))
+	}
+	if err := validateKeyName(name); err != nil {
+		return nil, err
+	}
+	target := filepath.Join(dir, trustedDir, name+publicKeyExt)
+	if existing, err := LoadPublicKey(target); err == nil && existing.ID != key.ID {
+		return nil, fmt.Errorf("trusted key %s already exists with id %s", name, existing.ID)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+		return nil, err
+	}
+	if err := writePublicKey(target, key.Key); err != nil {
+		return nil, err
+	}
+	key.Name = name
+	return key, nil
+}
+
+func ListKeys(dir string) ([]PublicKey, error) {
+	return loadPublicKeys(dir)
+}
+
+func LoadTrustedKeys(dir string) ([]PublicKey, error) {
+	return loadPublicKeys(filepath.Join(dir, trustedDir))
+}
+
+func loadPublicKeys(dir string) ([]PublicKey, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var keys []PublicKey
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != publicKeyExt {
+			continue
+		}
+		key, err := LoadPublicKey(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys, nil
+}
+
+func LoadPublicKey(path string) (*PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %s: %w", path, err)
+	}
+	pub, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an ed25519 key", path)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return &PublicKey{Name: name, ID: KeyID(pub), Key: pub}, nil
+}
+
+func LoadPrivateKey(path string) (*PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key %s: %w", path, err)
+	}
+	priv, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key %s is not an ed25519 key", path)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return &PrivateKey{Name: name, ID: KeyID(priv.Public().(ed25519.PublicKey)), Key: priv}, nil
+}
+
+func ResolvePrivateKey(dir, nameOrPath string) (*PrivateKey, error) {
+	if _, err := os.Stat(nameOrPath); err == nil {
+		return LoadPrivateKey(nameOrPath)
+	}
+	if validateKeyName(nameOrPath) == nil {
+		candidate := filepath.Join(dir, nameOrPath+privateKeyExt)
+		if _, err := os.Stat(candidate); err == nil {
+			return LoadPrivateKey(candidate)
+		}
+	}
+	return nil, fmt.Errorf("signing key %s not found (neither a file nor a key in %s)", nameOrPath, dir)
+}
+
+func SignFile(archivePath string, key *PrivateKey) (string, error) {
+	digest, err := fileDigest(archivePath)
+	if err != nil {
+		return "", err
+	}
+	sig := ed25519.Sign(key.Key, signedMessage(filepath.Base(archivePath), digest))
+	sigPath := archivePath + SignatureExt
+	line := fmt.Sprintf("%s %s %s\n", sigAlgorithm, key.ID, base64.StdEncoding.EncodeToString(sig))
+	if err := os.WriteFile(sigPath, []byte(line), 0o644); err != nil {
+		return "", err
+	}
+	return sigPath, nil
+}
+
+func VerifyFile(archivePath, sigPath string, trusted []PublicKey) (*PublicKey, error) {
+	data, err := os.ReadFile(sigPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrUnsigned
+		}
+		return nil, err
+	}
+	digest, err := fileDigest(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	return VerifyDigest(filepath.Base(archivePath), digest, data, trusted)
+}
+
+// VerifyDigest checks a signature file's contents against the SHA-256 of an
+// archive that has already been read, e.g. while streaming it.
+func VerifyDigest(name string, digest, signature []byte, trusted []PublicKey) (*PublicKey, error) {
+	keyID, sig, err := parseSignature(name+SignatureExt, signature)
+	if err != nil {
+		return nil, err
+	}
+	var key *PublicKey
+	for i := range trusted {
+		if trusted[i].ID == keyID {
+			key = &trusted[i]
+			break
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("archive %s is signed by %w %s", name, ErrUntrustedKey, keyID)
+	}
+	if !ed25519.Verify(key.Key, signedMessage(name, digest), sig) {
+		return nil, fmt.Errorf("archive %s has an invalid signature for key %s (%s)", name, key.Name, key.ID)
+	}
+	return key, nil
+}
+
+func parseSignature(name string, data []byte) (string, []byte, error) {
+	line, _, _ := strings.Cut(string(data), "\n")
+	if strings.TrimSpace(line) == "" {
+		return "", nil, fmt.Errorf("signature %s is empty", name)
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 3 || fields[0] != sigAlgorithm {
+		return "", nil, fmt.Errorf("signature %s has unsupported format", name)
+	}
+	sig, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return "", nil, fmt.Errorf("signature %s is malformed", name)
+	}
+	return fields[1], sig, nil
+}
+
+// signedMessage covers the archive's file name as well as its contents, so
+// that a signed archive cannot be published under another package's name or
+// version.
+func signedMessage(name string, digest []byte) []byte {
+	msg := append([]byte(sigContext), name...)
+	msg = append(msg, '\n')
+	return append(msg, digest...)
+}
+
+func fileDigest(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func writePublicKey(path string, pub ed25519.PublicKey) error {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return err
+	}
+	return writePEM(path, "PUBLIC KEY", der, 0o644)
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	return os.WriteFile(path, data, perm)
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM block", path)
+	}
+	return block, nil
+}
+
+func validateKeyName(name string) error {
+	if name == "" {
+		return errors.New("key name must not be empty")
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.':
+		default:
+			return fmt.Errorf("invalid key name %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package signing
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// signedArchive generates a key in dir, writes an archive and signs it.
+func signedArchive(t *testing.T, dir string) (archive string, key *PrivateKey, trusted []PublicKey) {
+	t.Helper()
+	key, err := Generate(dir, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := LoadPublicKey(filepath.Join(dir, "alice.pub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive = filepath.Join(dir, "app-1.0.tar.gz")
+	if err := os.WriteFile(archive, []byte("archive contents"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SignFile(archive, key); err != nil {
+		t.Fatal(err)
+	}
+	return archive, key, []PublicKey{*pub}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	dir := t.TempDir()
+	archive, key, trusted := signedArchive(t, dir)
+	got, err := VerifyFile(archive, archive+SignatureExt, trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != key.ID || got.Name != "alice" {
+		t.Errorf("got key %s (%s), want alice (%s)", got.Name, got.ID, key.ID)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		// change breaks the signed archive in dir and returns the archive
+		// and signature to verify.
+		change func(t *testing.T, archive string, trusted *[]PublicKey) (string, string)
+		err    string
+		is     error
+	}{
+		{
+			name: "unsigned",
+			change: func(t *testing.T, archive string, _ *[]PublicKey) (string, string) {
+				return archive, archive + ".missing"
+			},
+			is: ErrUnsigned,
+		},
+		{
+			name: "untrusted key",
+			change: func(t *testing.T, archive string, trusted *[]PublicKey) (string, string) {
+				other, err := Generate(t.TempDir(), "mallory")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := SignFile(archive, other); err != nil {
+					t.Fatal(err)
+				}
+				return archive, archive + SignatureExt
+			},
+			err: "is signed by untrusted key",
+			is:  ErrUntrustedKey,
+		},
+		{
+			name: "no trusted keys",
+			change: func(t *testing.T, archive string, trusted *[]PublicKey) (string, string) {
+				*trusted = nil
+				return archive, archive + SignatureExt
+			},
+			is: ErrUntrustedKey,
+		},
+		{
+			name: "tampered archive",
+			change: func(t *testing.T, archive string, _ *[]PublicKey) (string, string) {
+				if err := os.WriteFile(archive, []byte("archive contents!"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return archive, archive + SignatureExt
+			},
+			err: "app-1.0.tar.gz has an invalid signature for key alice",
+		},
+		{
+			name: "renamed archive",
+			change: func(t *testing.T, archive string, _ *[]PublicKey) (string, string) {
+				renamed := filepath.Join(filepath.Dir(archive), "app-9.9.tar.gz")
+				for _, ext := range []string{"", SignatureExt} {
+					if err := os.Rename(archive+ext, renamed+ext); err != nil {
+						t.Fatal(err)
+					}
+				}
+				return renamed, renamed + SignatureExt
+			},
+			err: "app-9.9.tar.gz has an invalid signature for key alice",
+		},
+		{
+			name: "empty signature",
+			change: func(t *testing.T, archive string, _ *[]PublicKey) (string, string) {
+				if err := os.WriteFile(archive+SignatureExt, nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return archive, archive + SignatureExt
+			},
+			err: "signature app-1.0.tar.gz.sig is empty",
+		},
+		{
+			name: "malformed signature",
+			change: func(t *testing.T, archive string, _ *[]PublicKey) (string, string) {
+				if err := os.WriteFile(archive+SignatureExt, []byte("ed25519 0011 bm90IGEgc2lnbmF0dXJl\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return archive, archive + SignatureExt
+			},
+			err: "signature app-1.0.tar.gz.sig is malformed",
+		},
+		{
+			name: "other algorithm",
+			change: func(t *testing.T, archive string, _ *[]PublicKey) (string, string) {
+				if err := os.WriteFile(archive+SignatureExt, []byte("rsa 0011 AAAA\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return archive, archive + SignatureExt
+			},
+			err: "has unsupported format",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive, _, trusted := signedArchive(t, t.TempDir())
+			archive, sig := tt.change(t, archive, &trusted)
+			_, err := VerifyFile(archive, sig, trusted)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tt.err != "" && !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %q, want %q", err, tt.err)
+			}
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Errorf("got %v, want %v", err, tt.is)
+			}
+		})
+	}
+}
+
+func TestLoadKeyErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Generate(dir, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	tests := []struct {
+		name string
+		load func() error
+		err  string
+	}{
+		{
+			name: "public key without PEM",
+			load: func() error { _, err := LoadPublicKey(write("bad.pub", "not a key\n")); return err },
+			err:  "does not contain a PEM block",
+		},
+		{
+			name: "public key with broken DER",
+			load: func() error {
+				_, err := LoadPublicKey(write("broken.pub", "-----BEGIN PUBLIC KEY-----\nAAAA\n-----END PUBLIC KEY-----\n"))
+				return err
+			},
+			err: "invalid public key",
+		},
+		{
+			name: "private key without PEM",
+			load: func() error { _, err := LoadPrivateKey(write("bad.key", "")); return err },
+			err:  "does not contain a PEM block",
+		},
+		{
+			name: "private key file as public key",
+			load: func() error { _, err := LoadPublicKey(filepath.Join(dir, "alice.key")); return err },
+			err:  "invalid public key",
+		},
+		{
+			name: "unknown signing key",
+			load: func() error { _, err := ResolvePrivateKey(dir, "bob"); return err },
+			err:  "signing key bob not found",
+		},
+		{
+			name: "invalid key name",
+			load: func() error { _, err := Generate(dir, "../x"); return err },
+			err:  `invalid key name "../x"`,
+		},
+		{
+			name: "existing key",
+			load: func() error { _, err := Generate(dir, "alice"); return err },
+			err:  "key alice already exists",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.load()
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestTrust(t *testing.T) {
+	dir := t.TempDir()
+	keys := t.TempDir()
+	key, err := Generate(keys, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := Trust(dir, filepath.Join(keys, "alice.pub"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub.Name != "alice" || pub.ID != key.ID {
+		t.Errorf("got %s (%s), want alice (%s)", pub.Name, pub.ID, key.ID)
+	}
+	trusted, err := LoadTrustedKeys(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trusted) != 1 || trusted[0].ID != key.ID {
+		t.Errorf("trusted keys: got %+v", trusted)
+	}
+
+	other := t.TempDir()
+	if _, err := Generate(other, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Trust(dir, filepath.Join(other, "alice.pub"), ""); err == nil || !strings.Contains(err.Error(), "trusted key alice already exists") {
+		t.Errorf("got %v, want an error for a different key under the same name", err)
+	}
+}
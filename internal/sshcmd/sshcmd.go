@@ -1,8 +1,10 @@
 package sshcmd
 
 import (
+	"archive/tar"
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path"
@@ -61,29 +63,118 @@ func RunSSH(c Config, command string) (string, error) {
 	return stdout.String(), nil
 }
 
-func UploadFile(c Config, localPath, remoteDir string) (string, error) {
+// ReadFile streams a remote file to w without storing it locally.
+func ReadFile(c Config, remotePath string, w io.Writer) error {
 	if c.Host == "" {
-		return "", fmt.Errorf("ssh host is required")
+		return fmt.Errorf("ssh host is required")
 	}
-	if remoteDir != "" {
-		if _, err := RunSSH(c, fmt.Sprintf("mkdir -p %s", ShellEscape(remoteDir))); err != nil {
-			return "", err
+	args := append(c.sshArgs(), c.target(), "cat "+ShellEscape(remotePath))
+	cmd := exec.Command("ssh", args...)
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ssh read of %s failed: %v: %s", remotePath, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// UploadFiles copies several files over a single ssh session and returns
+// their remote paths. The remote side creates remoteDir, removes the remote
+// paths in remove and unpacks the files from a tar stream, in the order
+// given.
+func UploadFiles(c Config, localPaths []string, remoteDir string, remove []string) ([]string, error) {
+	if c.Host == "" {
+		return nil, fmt.Errorf("ssh host is required")
+	}
+
+	var remotePaths []string
+	for _, localPath := range localPaths {
+		remotePath := filepath.Base(localPath)
+		if remoteDir != "" {
+			remotePath = path.Join(remoteDir, remotePath)
 		}
+		remotePaths = append(remotePaths, remotePath)
+	}
+	dest := remoteDir
+	if dest == "" {
+		dest = "."
 	}
 
-	remotePath := filepath.Base(localPath)
+	var steps []string
 	if remoteDir != "" {
-		remotePath = path.Join(remoteDir, remotePath)
+		steps = append(steps, "mkdir -p "+ShellEscape(remoteDir))
 	}
+	if len(remove) > 0 {
+		var quoted []string
+		for _, p := range remove {
+			quoted = append(quoted, ShellEscape(p))
+		}
+		steps = append(steps, "rm -f "+strings.Join(quoted, " "))
+	}
+	// -o keeps the remote user as the owner of the files.
+	steps = append(steps, "tar -xof - -C "+ShellEscape(dest))
 
-	args := append(c.scpArgs(), localPath, fmt.Sprintf("%s:%s", c.target(), remotePath))
-	cmd := exec.Command("scp", args...)
+	args := append(c.sshArgs(), c.target(), strings.Join(steps, " && "))
+	cmd := exec.Command("ssh", args...)
+	var stderr bytes.Buffer
 	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("scp upload failed: %w", err)
+	cmd.Stderr = &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("ssh upload failed: %w", err)
+	}
+	writeErr := writeTar(stdin, localPaths)
+	if err := stdin.Close(); writeErr == nil {
+		writeErr = err
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("ssh upload failed: %v: %s", err, strings.TrimSpace(stderr.String()))
 	}
-	return remotePath, nil
+	if writeErr != nil {
+		return nil, fmt.Errorf("ssh upload failed: %w", writeErr)
+	}
+	return remotePaths, nil
+}
+
+// writeTar streams files to w as a tar archive of their base names.
+func writeTar(w io.Writer, localPaths []string) error {
+	tw := tar.NewWriter(w)
+	for _, localPath := range localPaths {
+		if err := addTarFile(tw, localPath); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func addTarFile(tw *tar.Writer, localPath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", localPath)
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.Base(localPath)
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
 }
 
 func DownloadFile(c Config, remotePath, localDir string) (string, error) {
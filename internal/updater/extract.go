@@ -0,0 +1,386 @@
+package updater
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"pm/internal/archive"
+	"pm/internal/packager"
+)
+
+type ExtractLimits struct {
+	MaxTotalSize int64
+	MaxEntries   int
+}
+
+type stagedFile struct {
+	kind   string
+	link   string
+	size   int64
+	sha256 string
+}
+
+// stagedArchive is an archive unpacked and verified in a directory next to
+// its destination, waiting to be moved into place by commit.
+type stagedArchive struct {
+	dir      string
+	dest     string
+	files    map[string]stagedFile
+	manifest *packager.Manifest
+}
+
+func stageArchive(path, dest string, limits ExtractLimits) (*stagedArchive, error) {
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return nil, err
+	}
+	staging, err := os.MkdirTemp(dest, ".pm-staging-")
+	if err != nil {
+		return nil, err
+	}
+	s := &stagedArchive{dir: staging, dest: dest}
+
+	s.files, err = unpackArchive(path, staging, limits)
+	if err == nil {
+		err = checkSymlinks(path, s.files)
+	}
+	if err == nil {
+		s.manifest, err = verifyStaged(path, staging, s.files)
+	}
+	if err != nil {
+		s.cleanup()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *stagedArchive) commit() error {
+	return commitStaged(s.dir, s.dest)
+}
+
+func (s *stagedArchive) cleanup() {
+	os.RemoveAll(s.dir)
+}
+
+func unpackArchive(path, dest string, limits ExtractLimits) (map[string]stagedFile, error) {
+	ar, err := archive.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer ar.Close()
+
+	staged := map[string]stagedFile{}
+	var total int64
+
+	for {
+		header, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if limits.MaxEntries > 0 && len(staged) >= limits.MaxEntries {
+			return nil, fmt.Errorf("archive %s has more than %d entries", filepath.Base(path), limits.MaxEntries)
+		}
+		name, err := entryName(header.Name)
+		if err != nil {
+			return nil, fmt.Errorf("archive %s: %w", filepath.Base(path), err)
+		}
+		if _, dup := staged[name]; dup {
+			return nil, fmt.Errorf("archive %s: duplicate entry %s", filepath.Base(path), name)
+		}
+		if err := checkParents(dest, name); err != nil {
+			return nil, fmt.Errorf("archive %s: %w", filepath.Base(path), err)
+		}
+
+		targetPath := filepath.Join(dest, filepath.FromSlash(name))
+		if header.Type != archive.TypeDir {
+			if err := os.MkdirAll(filepath.Dir(targetPath), 0o755); err != nil {
+				return nil, err
+			}
+		}
+
+		switch header.Type {
+		case archive.TypeDir:
+			if err := os.MkdirAll(targetPath, sanitizeMode(header.Mode)); err != nil {
+				return nil, err
+			}
+			staged[name] = stagedFile{kind: packager.EntryDir}
+		case archive.TypeSymlink:
+			if err := checkLinkTarget(name, header.Linkname); err != nil {
+				return nil, fmt.Errorf("archive %s: %w", filepath.Base(path), err)
+			}
+			if err := os.Symlink(header.Linkname, targetPath); err != nil {
+				return nil, err
+			}
+			staged[name] = stagedFile{kind: packager.EntrySymlink, link: header.Linkname}
+		case archive.TypeHardlink:
+			linkName, err := entryName(header.Linkname)
+			if err != nil {
+				return nil, fmt.Errorf("archive %s: hardlink %s: %w", filepath.Base(path), name, err)
+			}
+			if target, ok := staged[linkName]; !ok || target.kind != packager.EntryFile {
+				return nil, fmt.Errorf("archive %s: hardlink %s must point to a regular file earlier in the archive, got %s", filepath.Base(path), name, header.Linkname)
+			}
+			if err := os.Link(filepath.Join(dest, filepath.FromSlash(linkName)), targetPath); err != nil {
+				return nil, err
+			}
+			staged[name] = stagedFile{kind: packager.EntryHardlink, link: linkName}
+		case archive.TypeFile:
+			file, err := os.OpenFile(targetPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, sanitizeMode(header.Mode))
+			if err != nil {
+				return nil, err
+			}
+			var src io.Reader = ar
+			if limits.MaxTotalSize > 0 {
+				src = io.LimitReader(ar, limits.MaxTotalSize-total+1)
+			}
+			h := sha256.New()
+			size, err := io.Copy(io.MultiWriter(file, h), src)
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			if err := file.Close(); err != nil {
+				return nil, err
+			}
+			total += size
+			if limits.MaxTotalSize > 0 && total > limits.MaxTotalSize {
+				return nil, fmt.Errorf("archive %s unpacks to more than %d bytes", filepath.Base(path), limits.MaxTotalSize)
+			}
+			staged[name] = stagedFile{kind: packager.EntryFile, size: size, sha256: hex.EncodeToString(h.Sum(nil))}
+		}
+	}
+	return staged, nil
+}
+
+// entryName normalises an archive entry name and rejects anything that would
+// land outside the extraction directory.
+func entryName(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("entry with empty name")
+	}
+	if strings.HasPrefix(name, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("entry %s has an absolute path", name)
+	}
+	cleaned := path.Clean(filepath.ToSlash(name))
+	if cleaned == "." || !filepath.IsLocal(filepath.FromSlash(cleaned)) {
+		return "", fmt.Errorf("entry %s escapes the destination directory", name)
+	}
+	return cleaned, nil
+}
+
+// checkParents refuses to write through a symlink that an earlier entry
+// created, which would otherwise let a link redirect later entries.
+func checkParents(root, name string) error {
+	dir := path.Dir(name)
+	if dir == "." {
+		return nil
+	}
+	current := root
+	for _, seg := range strings.Split(dir, "/") {
+		current = filepath.Join(current, seg)
+		info, err := os.Lstat(current)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("entry %s is inside symlink %s", name, seg)
+		}
+	}
+	return nil
+}
+
+func checkLinkTarget(name, target string) error {
+	if target == "" {
+		return fmt.Errorf("symlink %s has an empty target", name)
+	}
+	if strings.HasPrefix(target, "/") || filepath.IsAbs(target) {
+		return fmt.Errorf("symlink %s points to absolute path %s", name, target)
+	}
+	resolved := path.Join(path.Dir(name), filepath.ToSlash(target))
+	if !filepath.IsLocal(filepath.FromSlash(resolved)) && resolved != "." {
+		return fmt.Errorf("symlink %s points outside the destination directory (%s)", name, target)
+	}
+	return nil
+}
+
+const maxLinkDepth = 40
+
+// checkSymlinks resolves every staged symlink through the other staged links,
+// since a chain of individually harmless links can still climb out.
+func checkSymlinks(archivePath string, staged map[string]stagedFile) error {
+	for name, file := range staged {
+		if file.kind != packager.EntrySymlink {
+			continue
+		}
+		if _, err := resolveInside(staged, path.Dir(name), file.link, 0); err != nil {
+			return fmt.Errorf("archive %s: symlink %s: %w", filepath.Base(archivePath), name, err)
+		}
+	}
+	return nil
+}
+
+func resolveInside(staged map[string]stagedFile, dir, target string, depth int) (string, error) {
+	if depth > maxLinkDepth {
+		return "", errors.New("too many levels of symbolic links")
+	}
+	var stack []string
+	if dir != "." {
+		stack = strings.Split(dir, "/")
+	}
+	for _, seg := range strings.Split(filepath.ToSlash(target), "/") {
+		switch seg {
+		case "", ".":
+			continue
+		case "..":
+			if len(stack) == 0 {
+				return "", fmt.Errorf("target %s escapes the destination directory", target)
+			}
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		stack = append(stack, seg)
+		current := strings.Join(stack, "/")
+		if link, ok := staged[current]; ok && link.kind == packager.EntrySymlink {
+			resolved, err := resolveInside(staged, path.Dir(current), link.link, depth+1)
+			if err != nil {
+				return "", err
+			}
+			stack = nil
+			if resolved != "" {
+				stack = strings.Split(resolved, "/")
+			}
+		}
+	}
+	return strings.Join(stack, "/"), nil
+}
+
+// sanitizeMode drops setuid, setgid and sticky bits and world write access.
+func sanitizeMode(mode int64) os.FileMode {
+	return os.FileMode(mode).Perm() &^ 0o002
+}
+
+func verifyStaged(archivePath, staging string, staged map[string]stagedFile) (*packager.Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(staging, "manifest.json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("archive %s has no manifest.json", archivePath)
+		}
+		return nil, err
+	}
+	var manifest packager.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("archive %s has invalid manifest: %w", archivePath, err)
+	}
+
+	listed := map[string]struct{}{"manifest.json": {}}
+	for _, entry := range manifest.Files {
+		name := path.Clean(filepath.ToSlash(entry.Path))
+		listed[name] = struct{}{}
+		got, ok := staged[name]
+		if !ok {
+			return nil, fmt.Errorf("archive %s is missing %s listed in manifest", archivePath, entry.Path)
+		}
+		kind := entry.Type
+		if kind == "" {
+			kind = packager.EntryFile
+		}
+		if got.kind != kind {
+			return nil, fmt.Errorf("archive %s: %s is a %s but manifest lists a %s", archivePath, entry.Path, got.kind, kind)
+		}
+		switch kind {
+		case packager.EntryFile:
+			if entry.SHA256 == "" {
+				return nil, fmt.Errorf("archive %s manifest has no checksum for %s", archivePath, entry.Path)
+			}
+			if got.size != entry.Size {
+				return nil, fmt.Errorf("archive %s: size mismatch for %s: manifest %d, archive %d", archivePath, entry.Path, entry.Size, got.size)
+			}
+			if got.sha256 != entry.SHA256 {
+				return nil, fmt.Errorf("archive %s: checksum mismatch for %s", archivePath, entry.Path)
+			}
+		case packager.EntrySymlink, packager.EntryHardlink:
+			link := entry.Link
+			if kind == packager.EntryHardlink {
+				link = path.Clean(filepath.ToSlash(link))
+			}
+			if got.link != link {
+				return nil, fmt.Errorf("archive %s: link target mismatch for %s", archivePath, entry.Path)
+			}
+		}
+	}
+	for name := range staged {
+		if _, ok := listed[name]; !ok {
+			return nil, fmt.Errorf("archive %s contains %s which is not listed in manifest", archivePath, name)
+		}
+	}
+	return &manifest, nil
+}
+
+// commitStaged moves the staged tree into dest. Every entry is checked
+// first, so that a symlinked directory already in dest, e.g. one left by
+// another package, cannot send entries outside of it and nothing is moved
+// when one would.
+func commitStaged(staging, dest string) error {
+	var entries []string
+	err := filepath.WalkDir(staging, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(staging, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if err := checkParents(dest, filepath.ToSlash(rel)); err != nil {
+			return fmt.Errorf("cannot install into %s: %w", dest, err)
+		}
+		entries = append(entries, rel)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rel := range entries {
+		path := filepath.Join(staging, rel)
+		target := filepath.Join(dest, rel)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if err := os.MkdirAll(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			continue
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			// rename(2) replaces files and links but refuses to clobber a
+			// directory, which is the error we want to surface.
+			if existing, err := os.Lstat(target); err == nil && existing.IsDir() {
+				return fmt.Errorf("cannot replace directory %s with a symlink", target)
+			}
+		}
+		if err := os.Rename(path, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,358 @@
+package updater
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"pm/internal/packager"
+)
+
+type testEntry struct {
+	name string
+	typ  byte
+	link string
+	mode int64
+	data string
+}
+
+func regular(name, data string) testEntry {
+	return testEntry{name: name, typ: tar.TypeReg, data: data}
+}
+
+// writeTestArchive writes entries to a tar.gz as given, without any of the
+// checks the packager would apply.
+func writeTestArchive(t *testing.T, entries []testEntry) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pkg-1.0.tar.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		mode := e.mode
+		if mode == 0 {
+			mode = 0o644
+		}
+		h := &tar.Header{Name: e.name, Typeflag: e.typ, Linkname: e.link, Mode: mode, Size: int64(len(e.data))}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func fileEntry(path, data string) packager.FileEntry {
+	sum := sha256.Sum256([]byte(data))
+	return packager.FileEntry{Path: path, Type: packager.EntryFile, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+}
+
+func manifestEntry(t *testing.T, files ...packager.FileEntry) testEntry {
+	t.Helper()
+	data, err := json.Marshal(packager.Manifest{Name: "pkg", Version: "1.0", Files: files})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return regular("manifest.json", string(data))
+}
+
+// snapshot returns the paths and contents under dir.
+func snapshot(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	out := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		out[rel] = info.Mode().String()
+		if info.Mode().IsRegular() {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			out[rel] += " " + string(data)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// install stages and commits an archive the way installPackage does.
+func install(path, dest string, limits ExtractLimits) error {
+	stage, err := stageArchive(path, dest, limits)
+	if err != nil {
+		return err
+	}
+	defer stage.cleanup()
+	return stage.commit()
+}
+
+func TestStageArchiveLeavesDestUntouched(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries func(t *testing.T) []testEntry
+		err     string
+	}{
+		{
+			name: "wrong checksum",
+			entries: func(t *testing.T) []testEntry {
+				entry := fileEntry("bin/tool", "new")
+				entry.SHA256 = strings.Repeat("0", 64)
+				return []testEntry{manifestEntry(t, entry), regular("bin/tool", "new")}
+			},
+			err: "checksum mismatch for bin/tool",
+		},
+		{
+			name: "wrong size",
+			entries: func(t *testing.T) []testEntry {
+				entry := fileEntry("bin/tool", "new")
+				entry.Size++
+				return []testEntry{manifestEntry(t, entry), regular("bin/tool", "new")}
+			},
+			err: "size mismatch for bin/tool",
+		},
+		{
+			name: "listed entry missing",
+			entries: func(t *testing.T) []testEntry {
+				return []testEntry{manifestEntry(t, fileEntry("bin/tool", "new"), fileEntry("bin/other", "x")), regular("bin/tool", "new")}
+			},
+			err: "missing bin/other listed in manifest",
+		},
+		{
+			name: "entry not listed",
+			entries: func(t *testing.T) []testEntry {
+				return []testEntry{manifestEntry(t, fileEntry("bin/tool", "new")), regular("bin/tool", "new"), regular("bin/extra", "x")}
+			},
+			err: "contains bin/extra which is not listed in manifest",
+		},
+		{
+			name: "no manifest",
+			entries: func(t *testing.T) []testEntry {
+				return []testEntry{regular("bin/tool", "new")}
+			},
+			err: "has no manifest.json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(dest, "bin"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dest, "bin", "tool"), []byte("old"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			before := snapshot(t, dest)
+
+			err := install(writeTestArchive(t, tt.entries(t)), dest, ExtractLimits{})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+			if after := snapshot(t, dest); !reflect.DeepEqual(after, before) {
+				t.Fatalf("dest changed: %v, was %v", after, before)
+			}
+		})
+	}
+}
+
+func TestStageArchiveCommits(t *testing.T) {
+	dest := t.TempDir()
+	archive := writeTestArchive(t, []testEntry{
+		manifestEntry(t, fileEntry("bin/tool", "new")),
+		regular("bin/tool", "new"),
+	})
+	if err := install(archive, dest, ExtractLimits{}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "bin", "tool"))
+	if err != nil || string(data) != "new" {
+		t.Fatalf("bin/tool: got %q, %v", data, err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dest, ".pm-staging-*"))
+	if len(matches) > 0 {
+		t.Fatalf("staging directories left behind: %v", matches)
+	}
+}
+
+func TestStageArchiveRejectsUnsafeEntries(t *testing.T) {
+	symlink := func(name, target string) testEntry {
+		return testEntry{name: name, typ: tar.TypeSymlink, link: target}
+	}
+	hardlink := func(name, target string) testEntry {
+		return testEntry{name: name, typ: tar.TypeLink, link: target}
+	}
+	tests := []struct {
+		name    string
+		entries []testEntry
+		limits  ExtractLimits
+		err     string
+	}{
+		{name: "parent directory", entries: []testEntry{regular("../../evil", "x")}, err: "entry ../../evil escapes the destination directory"},
+		{name: "parent inside path", entries: []testEntry{regular("a/../../evil", "x")}, err: "escapes the destination directory"},
+		{name: "absolute path", entries: []testEntry{regular("/tmp/evil", "x")}, err: "entry /tmp/evil has an absolute path"},
+		{name: "empty name", entries: []testEntry{regular("", "x")}, err: "entry with empty name"},
+		{name: "duplicate", entries: []testEntry{regular("a", "x"), regular("./a", "y")}, err: "duplicate entry a"},
+		{name: "absolute symlink", entries: []testEntry{symlink("l", "/etc")}, err: "symlink l points to absolute path /etc"},
+		{name: "escaping symlink", entries: []testEntry{symlink("d/l", "../../x")}, err: "symlink d/l points outside the destination directory"},
+		{name: "empty symlink", entries: []testEntry{symlink("l", "")}, err: "symlink l has an empty target"},
+		{
+			name:    "chained symlinks",
+			entries: []testEntry{symlink("s", "."), symlink("t", "s/s/s/..")},
+			err:     "escapes the destination directory",
+		},
+		{
+			name:    "symlink through a sibling link",
+			entries: []testEntry{{name: "d/", typ: tar.TypeDir, mode: 0o755}, symlink("d/s", ".."), symlink("d/t", "s/..")},
+			err:     "symlink d/t: target s/.. escapes the destination directory",
+		},
+		{
+			name:    "symlink loop",
+			entries: []testEntry{symlink("a", "b"), symlink("b", "a")},
+			err:     "too many levels of symbolic links",
+		},
+		{
+			name:    "write through symlink",
+			entries: []testEntry{{name: "sub/", typ: tar.TypeDir, mode: 0o755}, symlink("l", "sub"), regular("l/f", "x")},
+			err:     "entry l/f is inside symlink l",
+		},
+		{name: "escaping hardlink", entries: []testEntry{hardlink("h", "../../etc/passwd")}, err: "hardlink h: entry ../../etc/passwd escapes the destination directory"},
+		{name: "absolute hardlink", entries: []testEntry{hardlink("h", "/etc/passwd")}, err: "hardlink h: entry /etc/passwd has an absolute path"},
+		{name: "hardlink to a later entry", entries: []testEntry{hardlink("h", "a"), regular("a", "x")}, err: "hardlink h must point to a regular file earlier in the archive"},
+		{name: "hardlink to a symlink", entries: []testEntry{symlink("l", "a"), hardlink("h", "l")}, err: "hardlink h must point to a regular file earlier in the archive"},
+		{
+			name:    "size limit",
+			entries: []testEntry{regular("a", "0123456"), regular("b", "0123456")},
+			limits:  ExtractLimits{MaxTotalSize: 10},
+			err:     "unpacks to more than 10 bytes",
+		},
+		{
+			name:    "entry limit",
+			entries: []testEntry{regular("a", "1"), regular("b", "1"), regular("c", "1"), regular("d", "1")},
+			limits:  ExtractLimits{MaxEntries: 3},
+			err:     "has more than 3 entries",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// dest is nested so that escaping entries stay inside root, where
+			// the test can see them.
+			root := t.TempDir()
+			dest := filepath.Join(root, "a", "dest")
+			if err := os.MkdirAll(dest, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			before := snapshot(t, root)
+
+			err := install(writeTestArchive(t, tt.entries), dest, tt.limits)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+			if after := snapshot(t, root); !reflect.DeepEqual(after, before) {
+				t.Fatalf("files changed: %v, was %v", after, before)
+			}
+		})
+	}
+}
+
+func TestStageArchiveLimitsAllowExactSize(t *testing.T) {
+	dest := t.TempDir()
+	archive := writeTestArchive(t, []testEntry{
+		manifestEntry(t, fileEntry("a", "01234")),
+		regular("a", "01234"),
+	})
+	// The manifest counts towards the limits like any other entry.
+	manifest := int64(len(manifestEntry(t, fileEntry("a", "01234")).data))
+	if err := install(archive, dest, ExtractLimits{MaxTotalSize: manifest + 5, MaxEntries: 2}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStageArchiveSanitizesModes(t *testing.T) {
+	dest := t.TempDir()
+	archive := writeTestArchive(t, []testEntry{
+		manifestEntry(t, fileEntry("bin/tool", "x"), packager.FileEntry{Path: "shared", Type: packager.EntryDir}),
+		{name: "bin/tool", typ: tar.TypeReg, mode: 0o6777, data: "x"},
+		{name: "shared/", typ: tar.TypeDir, mode: 0o1777},
+	})
+	if err := install(archive, dest, ExtractLimits{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"bin/tool", "shared"} {
+		info, err := os.Stat(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode(); mode&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky) != 0 || mode.Perm()&0o002 != 0 {
+			t.Errorf("%s: mode %v keeps special bits or world write access", name, mode)
+		}
+	}
+}
+
+// A symlinked directory already in dest, e.g. from another package, must not
+// redirect entries out of it when the staged tree is moved in.
+func TestCommitRefusesSymlinkedParents(t *testing.T) {
+	tests := []struct {
+		name string
+		link string
+		err  string
+	}{
+		{name: "top level", link: "lib", err: "entry lib/evil is inside symlink lib"},
+		{name: "nested", link: "lib/sub", err: "entry lib/sub/evil is inside symlink sub"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dest := filepath.Join(root, "dest")
+			outside := filepath.Join(root, "outside")
+			for _, dir := range []string{outside, filepath.Dir(filepath.Join(dest, tt.link))} {
+				if err := os.MkdirAll(dir, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Symlink(outside, filepath.Join(dest, tt.link)); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dest, "keep"), []byte("old"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			evil := tt.link + "/evil"
+			archive := writeTestArchive(t, []testEntry{
+				manifestEntry(t, fileEntry("keep", "new"), fileEntry(evil, "x")),
+				regular("keep", "new"),
+				regular(evil, "x"),
+			})
+			before := snapshot(t, root)
+
+			err := install(archive, dest, ExtractLimits{})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got error %v, want %q", err, tt.err)
+			}
+			if after := snapshot(t, root); !reflect.DeepEqual(after, before) {
+				t.Fatalf("files changed: %v, was %v", after, before)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package updater
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"pm/internal/archive"
+	"pm/internal/config"
+	"pm/internal/packager"
+	"pm/internal/signing"
+	"pm/internal/sshcmd"
+)
+
+type InspectOptions struct {
+	RemoteDir   string
+	SSH         sshcmd.Config
+	TrustedKeys []signing.PublicKey
+	// Platform picks among os/arch builds when a package is looked up by
+	// name. It defaults to the host's.
+	Platform config.Platform
+}
+
+type Inspection struct {
+	Archive   string             `json:"archive"`
+	Remote    bool               `json:"remote"`
+	Format    string             `json:"format"`
+	Size      int64              `json:"size"`
+	SHA256    string             `json:"sha256"`
+	Signature SignatureStatus    `json:"signature"`
+	Manifest  *packager.Manifest `json:"manifest"`
+}
+
+const (
+	SignatureValid     = "valid"
+	SignatureUnsigned  = "unsigned"
+	SignatureUntrusted = "untrusted"
+	SignatureInvalid   = "invalid"
+)
+
+type SignatureStatus struct {
+	Status string `json:"status"`
+	Key    string `json:"key,omitempty"`
+	KeyID  string `json:"key_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Inspect reads the manifest of a local archive, or of a remote package
+// given as name or name@constraint, without installing it. Tar archives are
+// streamed; only remote zip archives are downloaded to a temporary
+// directory first.
+func Inspect(target string, opts InspectOptions) (*Inspection, error) {
+	if info, err := os.Stat(target); err == nil && !info.IsDir() {
+		return inspectLocal(target, opts)
+	}
+	if opts.SSH.Host == "" {
+		return nil, fmt.Errorf("%s is not a local archive and no ssh host is set to look it up remotely", target)
+	}
+	return inspectRemote(target, opts)
+}
+
+func inspectLocal(archivePath string, opts InspectOptions) (*Inspection, error) {
+	insp, digest, err := readArchiveFile(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := os.ReadFile(archivePath + signing.SignatureExt)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	insp.Signature = signatureStatus(filepath.Base(archivePath), digest, sig, err == nil, opts.TrustedKeys)
+	return insp, nil
+}
+
+// readArchiveFile reads the manifest and digest of an archive file; the
+// signature is left to the caller.
+func readArchiveFile(archivePath string) (*Inspection, []byte, error) {
+	format, _, ok := archive.ForFile(archivePath)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported archive %s", archivePath)
+	}
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var manifest *packager.Manifest
+	hr := newHashingReader(f)
+	if format.Streaming {
+		manifest, err = readStreamManifest(hr)
+	} else {
+		manifest, err = readFileManifest(format, archivePath)
+		if err == nil {
+			_, err = io.Copy(io.Discard, hr)
+		}
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", archivePath, err)
+	}
+	return &Inspection{
+		Archive:  archivePath,
+		Format:   format.Name,
+		Size:     hr.n,
+		SHA256:   hex.EncodeToString(hr.digest()),
+		Manifest: manifest,
+	}, hr.digest(), nil
+}
+
+func readFileManifest(format *archive.Format, archivePath string) (*packager.Manifest, error) {
+	r, err := format.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readManifest(r)
+}
+
+func inspectRemote(target string, opts InspectOptions) (*Inspection, error) {
+	name, constraint, _ := strings.Cut(target, "@")
+	if opts.Platform.IsAny() {
+		opts.Platform = config.HostPlatform()
+	}
+	entries, err := listRemoteArchives(opts.SSH, opts.RemoteDir)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []remotePackage
+	for _, pkg := range entries {
+		if pkg.Name == name {
+			candidates = append(candidates, pkg)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("package %s not found on remote", name)
+	}
+	sortPackages(candidates)
+	pkg, err := selectVersion(candidates, constraint, opts.Platform)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig []byte
+	if pkg.SignaturePath != "" {
+		var buf bytes.Buffer
+		if err := sshcmd.ReadFile(opts.SSH, pkg.SignaturePath, &buf); err != nil {
+			return nil, err
+		}
+		sig = buf.Bytes()
+	}
+
+	format, _, _ := archive.ForFile(pkg.Path)
+	if !format.Streaming {
+		tmp, err := os.MkdirTemp("", "pm-inspect-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmp)
+		localArchive, err := sshcmd.DownloadFile(opts.SSH, pkg.Path, tmp)
+		if err != nil {
+			return nil, err
+		}
+		insp, digest, err := readArchiveFile(localArchive)
+		if err != nil {
+			return nil, err
+		}
+		insp.Archive, insp.Remote = pkg.Path, true
+		insp.Signature = signatureStatus(path.Base(pkg.Path), digest, sig, pkg.SignaturePath != "", opts.TrustedKeys)
+		return insp, nil
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := sshcmd.ReadFile(opts.SSH, pkg.Path, pw)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	hr := newHashingReader(pr)
+	manifest, err := readStreamManifest(hr)
+	pr.CloseWithError(errors.New("inspect finished reading"))
+	if sshErr := <-done; err == nil && sshErr != nil {
+		err = sshErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", pkg.Path, err)
+	}
+
+	return &Inspection{
+		Archive:   pkg.Path,
+		Remote:    true,
+		Format:    format.Name,
+		Size:      hr.n,
+		SHA256:    hex.EncodeToString(hr.digest()),
+		Signature: signatureStatus(path.Base(pkg.Path), hr.digest(), sig, pkg.SignaturePath != "", opts.TrustedKeys),
+		Manifest:  manifest,
+	}, nil
+}
+
+// readStreamManifest reads the manifest from a tar stream and then the rest
+// of the stream, so that r has seen, and hashed, every byte.
+func readStreamManifest(r io.Reader) (*packager.Manifest, error) {
+	tr, err := archive.NewTarReader(r)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readManifest(tr)
+	tr.Close()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// readManifest returns the manifest.json entry, which create writes first.
+func readManifest(r archive.Reader) (*packager.Manifest, error) {
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return nil, errors.New("archive has no manifest.json")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Name != "manifest.json" {
+			continue
+		}
+		var manifest packager.Manifest
+		if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+			return nil, fmt.Errorf("invalid manifest.json: %w", err)
+		}
+		return &manifest, nil
+	}
+}
+
+// signatureStatus checks sig against the trusted keys. signed tells whether
+// the archive has a signature file at all: an empty or unparsable one is
+// invalid, not missing.
+func signatureStatus(name string, digest, sig []byte, signed bool, trusted []signing.PublicKey) SignatureStatus {
+	if !signed {
+		return SignatureStatus{Status: SignatureUnsigned}
+	}
+	key, err := signing.VerifyDigest(name, digest, sig, trusted)
+	switch {
+	case err == nil:
+		return SignatureStatus{Status: SignatureValid, Key: key.Name, KeyID: key.ID}
+	case errors.Is(err, signing.ErrUntrustedKey):
+		return SignatureStatus{Status: SignatureUntrusted, Error: err.Error()}
+	default:
+		return SignatureStatus{Status: SignatureInvalid, Error: err.Error()}
+	}
+}
+
+// hashingReader hashes and counts everything read through it.
+type hashingReader struct {
+	r io.Reader
+	h hash.Hash
+	n int64
+}
+
+func newHashingReader(r io.Reader) *hashingReader {
+	return &hashingReader{r: r, h: sha256.New()}
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *hashingReader) digest() []byte {
+	return r.h.Sum(nil)
+}
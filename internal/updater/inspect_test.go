@@ -0,0 +1,108 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"pm/internal/signing"
+)
+
+func TestInspectSignatureStatus(t *testing.T) {
+	keys := t.TempDir()
+	key, err := signing.Generate(keys, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := signing.LoadPublicKey(filepath.Join(keys, "alice.pub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := signing.Generate(keys, "mallory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted := []signing.PublicKey{*pub}
+
+	tests := []struct {
+		name string
+		// sign writes the signature of the archive, if any.
+		sign   func(t *testing.T, archivePath string)
+		status string
+		err    string
+	}{
+		{name: "no signature", sign: func(*testing.T, string) {}, status: SignatureUnsigned},
+		{
+			name: "valid",
+			sign: func(t *testing.T, archivePath string) {
+				if _, err := signing.SignFile(archivePath, key); err != nil {
+					t.Fatal(err)
+				}
+			},
+			status: SignatureValid,
+		},
+		{
+			name: "untrusted key",
+			sign: func(t *testing.T, archivePath string) {
+				if _, err := signing.SignFile(archivePath, other); err != nil {
+					t.Fatal(err)
+				}
+			},
+			status: SignatureUntrusted,
+			err:    "untrusted key",
+		},
+		{
+			name: "empty signature",
+			sign: func(t *testing.T, archivePath string) {
+				if err := os.WriteFile(archivePath+signing.SignatureExt, nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			status: SignatureInvalid,
+			err:    "is empty",
+		},
+		{
+			name: "unparsable signature",
+			sign: func(t *testing.T, archivePath string) {
+				if err := os.WriteFile(archivePath+signing.SignatureExt, []byte("not a signature\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			status: SignatureInvalid,
+			err:    "unsupported format",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archivePath := writeTestArchive(t, []testEntry{
+				manifestEntry(t, fileEntry("bin/app", "app")),
+				regular("bin/app", "app"),
+			})
+			tt.sign(t, archivePath)
+			insp, err := Inspect(archivePath, InspectOptions{TrustedKeys: trusted})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := insp.Signature
+			if got.Status != tt.status || !strings.Contains(got.Error, tt.err) {
+				t.Errorf("got %+v, want %s with error %q", got, tt.status, tt.err)
+			}
+			if tt.status == SignatureValid && got.Key != "alice" {
+				t.Errorf("got key %q, want alice", got.Key)
+			}
+		})
+	}
+}
+
+// A remote .sig that reads back empty has no bytes at all; it is still a
+// signature file, and so invalid rather than unsigned.
+func TestSignatureStatusEmptyRemote(t *testing.T) {
+	got := signatureStatus("app-1.0.tar.gz", make([]byte, 32), nil, true, nil)
+	if got.Status != SignatureInvalid || !strings.Contains(got.Error, "signature app-1.0.tar.gz.sig is empty") {
+		t.Errorf("got %+v, want an invalid, empty signature", got)
+	}
+	if got := signatureStatus("app-1.0.tar.gz", make([]byte, 32), nil, false, nil); got.Status != SignatureUnsigned {
+		t.Errorf("got %+v, want unsigned", got)
+	}
+}
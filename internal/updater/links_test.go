@@ -0,0 +1,138 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"pm/internal/config"
+	"pm/internal/packager"
+)
+
+// linkTree writes a tree with a symlink to a file, a symlink to a
+// directory and a hard link.
+func linkTree(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, data := range map[string]string{"bin/tool": "tool", "lib/a.so": "lib"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("tool", filepath.Join(root, "bin", "alias")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(root, "lib", "a.so"), filepath.Join(root, "lib", "b.so")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "share"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../lib", filepath.Join(root, "share", "lib")); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// describe lists the files under dir, but for the manifest, as symlinks
+// with their targets or as regular files with their contents.
+func describe(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	out := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		rel = filepath.ToSlash(rel)
+		if rel == "manifest.json" {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			out[rel] = "-> " + target
+			return err
+		}
+		data, err := os.ReadFile(path)
+		out[rel] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestCreateAndInstallLinks(t *testing.T) {
+	root := linkTree(t)
+	tests := []struct {
+		format string
+		follow bool
+		want   map[string]string
+	}{
+		{
+			format: "tar.gz",
+			want:   map[string]string{"bin/tool": "tool", "bin/alias": "-> tool", "lib/a.so": "lib", "lib/b.so": "lib", "share/lib": "-> ../lib"},
+		},
+		{
+			format: "zip",
+			want:   map[string]string{"bin/tool": "tool", "bin/alias": "-> tool", "lib/a.so": "lib", "lib/b.so": "lib", "share/lib": "-> ../lib"},
+		},
+		{
+			// Followed links are packaged as what they point to.
+			format: "tar.gz",
+			follow: true,
+			want: map[string]string{
+				"bin/tool": "tool", "bin/alias": "tool", "lib/a.so": "lib", "lib/b.so": "lib",
+				"share/lib/a.so": "lib", "share/lib/b.so": "lib",
+			},
+		},
+	}
+	for _, tt := range tests {
+		name := tt.format
+		if tt.follow {
+			name += "+follow"
+		}
+		t.Run(name, func(t *testing.T) {
+			spec := &config.PackageSpec{
+				Name:           "app",
+				Version:        "1.0",
+				Targets:        []config.TargetSpec{{Patterns: []string{"bin/*", "lib/*", "share/**"}}},
+				FollowSymlinks: tt.follow,
+			}
+			archivePath, _, err := packager.Create(spec, packager.CreateOptions{
+				WorkingDir: root,
+				OutputPath: filepath.Join(t.TempDir(), "app-1.0."+tt.format),
+				Format:     tt.format,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			dest := t.TempDir()
+			if err := install(archivePath, dest, ExtractLimits{}); err != nil {
+				t.Fatal(err)
+			}
+			if got := describe(t, dest); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+
+			// tar keeps hard links as links, zip has to copy them.
+			a, err := os.Stat(filepath.Join(dest, "lib", "a.so"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.Stat(filepath.Join(dest, "lib", "b.so"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if linked := os.SameFile(a, b); linked != (tt.format != "zip") {
+				t.Errorf("lib/b.so linked to lib/a.so: %v", linked)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package updater
+
+import (
+	"sort"
+
+	"pm/internal/packager"
+)
+
+// Installed returns the manifests of the packages installed in dir, one per
+// package (the newest version if older manifests were left behind), sorted
+// by name.
+func Installed(dir string) ([]*packager.Manifest, error) {
+	if dir == "" {
+		dir = "."
+	}
+	found, err := readInstalled(dir, "manifest-*.json")
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var manifests []*packager.Manifest
+	for _, inst := range found {
+		if inst.manifest.Name == "" || seen[inst.manifest.Name] {
+			continue
+		}
+		seen[inst.manifest.Name] = true
+		manifests = append(manifests, inst.manifest)
+	}
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].Name < manifests[j].Name })
+	return manifests, nil
+}
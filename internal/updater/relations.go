@@ -0,0 +1,209 @@
+package updater
+
+import (
+	"fmt"
+	"sort"
+
+	"pm/internal/config"
+	"pm/internal/packager"
+)
+
+// installState tracks what update has installed so far and what was already
+// in the local directory, so conflicts, provides and replaces can be checked
+// against both.
+type installState struct {
+	versions map[string]Version
+	present  map[string]*packager.Manifest
+}
+
+func newInstallState(dir string) (*installState, error) {
+	manifests, err := Installed(dir)
+	if err != nil {
+		return nil, err
+	}
+	state := &installState{
+		versions: map[string]Version{},
+		present:  map[string]*packager.Manifest{},
+	}
+	for _, m := range manifests {
+		state.present[m.Name] = m
+	}
+	return state, nil
+}
+
+// names returns the installed package names in a stable order.
+func (s *installState) names() []string {
+	names := make([]string, 0, len(s.present))
+	for name := range s.present {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// provider returns an installed package other than dep.Name that provides or
+// replaces it, or nil.
+func (s *installState) provider(dep config.DependencySpec) (*packager.Manifest, error) {
+	for _, name := range s.names() {
+		m := s.present[name]
+		if m.Name == dep.Name {
+			continue
+		}
+		for _, p := range m.Provides {
+			if p.Name != dep.Name {
+				continue
+			}
+			ok, err := providedMatches(p.Version, dep.Version)
+			if err != nil {
+				return nil, fmt.Errorf("%s provides %s: %w", m.Name, p, err)
+			}
+			if ok {
+				return m, nil
+			}
+		}
+		for _, r := range m.Replaces {
+			if r.Name == dep.Name && dep.Version == "" {
+				return m, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// providedMatches reports whether a provided version satisfies a
+// constraint. Unversioned provides only satisfy unversioned dependencies.
+func providedMatches(version, constraint string) (bool, error) {
+	if constraint == "" {
+		return true, nil
+	}
+	if version == "" {
+		return false, nil
+	}
+	return versionMatches(version, constraint)
+}
+
+func versionMatches(version, constraint string) (bool, error) {
+	if constraint == "" {
+		return true, nil
+	}
+	c, err := ParseConstraint(constraint)
+	if err != nil {
+		return false, err
+	}
+	v, err := ParseVersion(version)
+	if err != nil {
+		return false, err
+	}
+	return c.Matches(v), nil
+}
+
+// relationMatches reports whether a conflicts or replaces entry names the
+// package, directly or through one of its provides.
+func relationMatches(rel config.DependencySpec, m *packager.Manifest) (bool, error) {
+	if rel.Name == m.Name {
+		return versionMatches(m.Version, rel.Version)
+	}
+	for _, p := range m.Provides {
+		if p.Name != rel.Name {
+			continue
+		}
+		ok, err := providedMatches(p.Version, rel.Version)
+		if ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// checkConflicts refuses m if it conflicts with an installed package or an
+// installed package conflicts with it. Packages m replaces are exempt, as
+// they are removed before m is put in place.
+func (s *installState) checkConflicts(m *packager.Manifest) error {
+	replaced, err := s.replaced(m)
+	if err != nil {
+		return err
+	}
+	for _, name := range s.names() {
+		other := s.present[name]
+		if name == m.Name || replaced[name] != nil {
+			continue
+		}
+		for _, c := range m.Conflicts {
+			ok, err := relationMatches(c, other)
+			if err != nil {
+				return fmt.Errorf("%s conflicts with %s: %w", m.Name, c, err)
+			}
+			if ok {
+				return fmt.Errorf("cannot install %s %s: it conflicts with installed %s %s (%s declares conflicts: %s)", m.Name, m.Version, other.Name, other.Version, m.Name, c)
+			}
+		}
+		for _, c := range other.Conflicts {
+			ok, err := relationMatches(c, m)
+			if err != nil {
+				return fmt.Errorf("%s conflicts with %s: %w", other.Name, c, err)
+			}
+			if ok {
+				return fmt.Errorf("cannot install %s %s: installed %s %s conflicts with it (%s declares conflicts: %s)", m.Name, m.Version, other.Name, other.Version, other.Name, c)
+			}
+		}
+	}
+	return nil
+}
+
+// replaced returns the installed packages that m replaces, by name.
+func (s *installState) replaced(m *packager.Manifest) (map[string]*packager.Manifest, error) {
+	found := map[string]*packager.Manifest{}
+	for _, r := range m.Replaces {
+		other := s.present[r.Name]
+		if other == nil || other.Name == m.Name {
+			continue
+		}
+		ok, err := versionMatches(other.Version, r.Version)
+		if err != nil {
+			return nil, fmt.Errorf("%s replaces %s: %w", m.Name, r, err)
+		}
+		if ok {
+			found[r.Name] = other
+		}
+	}
+	return found, nil
+}
+
+// removeReplaced uninstalls the packages m replaces and returns them as
+// "name version".
+func (s *installState) removeReplaced(m *packager.Manifest, opts UpdateOptions) ([]string, error) {
+	replaced, err := s.replaced(m)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(replaced))
+	for name := range replaced {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var removed []string
+	for _, name := range names {
+		res, err := Remove(name, RemoveOptions{LocalDir: opts.LocalDir, NoScripts: opts.NoScripts})
+		if err != nil {
+			return nil, fmt.Errorf("%s replaces %s: %w", m.Name, name, err)
+		}
+		delete(s.present, name)
+		removed = append(removed, res.PackageName+" "+res.Version)
+	}
+	return removed, nil
+}
+
+// wanted reports whether a dependency of parent should be installed.
+// Optional ones are only installed on request.
+func wanted(child, parent config.DependencySpec, opts UpdateOptions) bool {
+	if !child.Optional || opts.WithOptional {
+		return true
+	}
+	for _, name := range parent.With {
+		if name == child.Name {
+			return true
+		}
+	}
+	return false
+}
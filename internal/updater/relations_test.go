@@ -0,0 +1,206 @@
+package updater
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"pm/internal/config"
+	"pm/internal/packager"
+)
+
+func depSpec(name, version string) config.DependencySpec {
+	return config.DependencySpec{Name: name, Version: version}
+}
+
+// installManifest writes the files of m into dir and records m as
+// installed, the way update leaves a package behind.
+func installManifest(t *testing.T, dir string, m packager.Manifest, files ...string) {
+	t.Helper()
+	for _, name := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		m.Files = append(m.Files, fileEntry(name, name))
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, manifestFilename(m.Name, m.Version)), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func stateWith(t *testing.T, installed ...packager.Manifest) (*installState, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, m := range installed {
+		installManifest(t, dir, m)
+	}
+	state, err := newInstallState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state, dir
+}
+
+func TestCheckConflicts(t *testing.T) {
+	sendmail := packager.Manifest{Name: "sendmail", Version: "8.17", Provides: []config.DependencySpec{depSpec("mail-transport", "")}}
+	tests := []struct {
+		name      string
+		installed []packager.Manifest
+		pkg       packager.Manifest
+		err       string
+	}{
+		{
+			name:      "declared by the new package",
+			installed: []packager.Manifest{sendmail},
+			pkg:       packager.Manifest{Name: "postfix", Version: "3.8", Conflicts: []config.DependencySpec{depSpec("sendmail", "")}},
+			err:       "cannot install postfix 3.8: it conflicts with installed sendmail 8.17 (postfix declares conflicts: sendmail)",
+		},
+		{
+			name:      "declared by the installed package",
+			installed: []packager.Manifest{{Name: "old-cli", Version: "1.0", Conflicts: []config.DependencySpec{depSpec("cli", "<2")}}},
+			pkg:       packager.Manifest{Name: "cli", Version: "1.5"},
+			err:       "cannot install cli 1.5: installed old-cli 1.0 conflicts with it (old-cli declares conflicts: cli <2)",
+		},
+		{
+			name:      "version outside the conflict",
+			installed: []packager.Manifest{{Name: "old-cli", Version: "1.0", Conflicts: []config.DependencySpec{depSpec("cli", "<2")}}},
+			pkg:       packager.Manifest{Name: "cli", Version: "2.0"},
+		},
+		{
+			name:      "through provides",
+			installed: []packager.Manifest{sendmail},
+			pkg:       packager.Manifest{Name: "postfix", Version: "3.8", Conflicts: []config.DependencySpec{depSpec("mail-transport", "")}},
+			err:       "it conflicts with installed sendmail 8.17 (postfix declares conflicts: mail-transport)",
+		},
+		{
+			name:      "replaced package is exempt",
+			installed: []packager.Manifest{sendmail},
+			pkg: packager.Manifest{
+				Name: "postfix", Version: "3.8",
+				Conflicts: []config.DependencySpec{depSpec("sendmail", "")},
+				Replaces:  []config.DependencySpec{depSpec("sendmail", "")},
+			},
+		},
+		{
+			name:      "upgrade of itself",
+			installed: []packager.Manifest{{Name: "postfix", Version: "3.7", Conflicts: []config.DependencySpec{depSpec("postfix", "<3.8")}}},
+			pkg:       packager.Manifest{Name: "postfix", Version: "3.8"},
+		},
+		{
+			name:      "invalid constraint",
+			installed: []packager.Manifest{sendmail},
+			pkg:       packager.Manifest{Name: "postfix", Version: "3.8", Conflicts: []config.DependencySpec{depSpec("sendmail", "<<9")}},
+			err:       "postfix conflicts with sendmail <<9",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, _ := stateWith(t, tt.installed...)
+			err := state.checkConflicts(&tt.pkg)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestProvider(t *testing.T) {
+	state, _ := stateWith(t,
+		packager.Manifest{Name: "postfix", Version: "3.8", Provides: []config.DependencySpec{depSpec("mail-transport", ""), depSpec("smtpd", "3.8")}},
+		packager.Manifest{Name: "libfoo2", Version: "2.0", Replaces: []config.DependencySpec{depSpec("libfoo", "")}},
+	)
+	tests := []struct {
+		dep  config.DependencySpec
+		want string
+	}{
+		{depSpec("mail-transport", ""), "postfix"},
+		// An unversioned provide does not satisfy a version constraint.
+		{depSpec("mail-transport", ">=1"), ""},
+		{depSpec("smtpd", ">=3"), "postfix"},
+		{depSpec("smtpd", ">=4"), ""},
+		{depSpec("libfoo", ""), "libfoo2"},
+		// Replacing a package does not say which of its versions it stands in for.
+		{depSpec("libfoo", ">=1"), ""},
+		// A package does not provide itself.
+		{depSpec("postfix", ""), ""},
+		{depSpec("exim", ""), ""},
+	}
+	for _, tt := range tests {
+		got, err := state.provider(tt.dep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := ""
+		if got != nil {
+			name = got.Name
+		}
+		if name != tt.want {
+			t.Errorf("provider of %s: got %q, want %q", tt.dep, name, tt.want)
+		}
+	}
+
+	if _, err := state.provider(depSpec("smtpd", "<<3")); err == nil || !strings.Contains(err.Error(), "postfix provides smtpd 3.8") {
+		t.Errorf("got %v, want an invalid constraint error", err)
+	}
+}
+
+func TestRemoveReplaced(t *testing.T) {
+	dir := t.TempDir()
+	installManifest(t, dir, packager.Manifest{Name: "libfoo", Version: "1.4"}, "lib/libfoo.so", "share/foo/README")
+	installManifest(t, dir, packager.Manifest{Name: "libbar", Version: "1.0"}, "lib/libbar.so")
+	state, err := newInstallState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := UpdateOptions{LocalDir: dir, NoScripts: true}
+
+	// A version the constraint excludes stays.
+	removed, err := state.removeReplaced(&packager.Manifest{Name: "libfoo2", Version: "2.0", Replaces: []config.DependencySpec{depSpec("libfoo", "<1")}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Fatalf("removed %v", removed)
+	}
+
+	m := &packager.Manifest{Name: "libfoo2", Version: "2.0", Replaces: []config.DependencySpec{depSpec("libfoo", "<2"), depSpec("libbaz", "")}}
+	removed, err = state.removeReplaced(m, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"libfoo 1.4"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed %v, want %v", removed, want)
+	}
+	if state.present["libfoo"] != nil || state.present["libbar"] == nil {
+		t.Errorf("installed after removal: %v", state.names())
+	}
+	for _, name := range []string{"lib/libfoo.so", "share/foo", manifestFilename("libfoo", "1.4")} {
+		if _, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("%s left behind: %v", name, err)
+		}
+	}
+	installed, err := Installed(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(installed) != 1 || installed[0].Name != "libbar" {
+		t.Errorf("installed: got %+v, want only libbar", installed)
+	}
+}
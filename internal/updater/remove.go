@@ -0,0 +1,183 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pm/internal/config"
+	"pm/internal/packager"
+)
+
+type RemoveOptions struct {
+	LocalDir  string
+	NoScripts bool
+}
+
+type RemoveResult struct {
+	PackageName string
+	Version     string
+	Removed     int
+}
+
+type installedManifest struct {
+	path     string
+	manifest *packager.Manifest
+	version  Version
+}
+
+// Remove deletes an installed package: its files, its manifest and its
+// scripts. Leftover manifests of older versions are cleaned up as well; the
+// scripts of the newest one are run.
+func Remove(name string, opts RemoveOptions) (*RemoveResult, error) {
+	dir := opts.LocalDir
+	if dir == "" {
+		dir = "."
+	}
+	found, err := findInstalled(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("package %s is not installed in %s", name, dir)
+	}
+	current := found[0].manifest
+
+	if !opts.NoScripts {
+		if err := runHook(current, dir, config.HookPreRemove, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	metaDir := packager.MetaDir(name)
+	removed := 0
+	var dirs []string
+	for _, inst := range found {
+		for _, entry := range inst.manifest.Files {
+			rel, err := entryName(entry.Path)
+			if err != nil {
+				return nil, fmt.Errorf("manifest %s: %w", inst.path, err)
+			}
+			if rel == metaDir || strings.HasPrefix(rel, metaDir+"/") {
+				continue
+			}
+			if entry.Type == packager.EntryDir {
+				dirs = append(dirs, rel)
+				continue
+			}
+			ok, err := removeFile(dir, rel)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				removed++
+			}
+			dirs = append(dirs, parentDirs(rel)...)
+		}
+	}
+	removeEmptyDirs(dir, dirs)
+
+	if !opts.NoScripts {
+		if err := runHook(current, dir, config.HookPostRemove, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.RemoveAll(filepath.Join(dir, filepath.FromSlash(metaDir))); err != nil {
+		return nil, err
+	}
+	removeEmptyDirs(dir, []string{path.Dir(metaDir)})
+	for _, inst := range found {
+		if err := os.Remove(inst.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return &RemoveResult{PackageName: name, Version: current.Version, Removed: removed}, nil
+}
+
+// findInstalled returns the manifests left by update for the package, newest
+// version first.
+func findInstalled(dir, name string) ([]installedManifest, error) {
+	found, err := readInstalled(dir, fmt.Sprintf("manifest-%s-*.json", sanitizeName(name)))
+	if err != nil {
+		return nil, err
+	}
+	// manifest-a-*.json also matches package a-b.
+	matching := found[:0]
+	for _, inst := range found {
+		if inst.manifest.Name == name {
+			matching = append(matching, inst)
+		}
+	}
+	return matching, nil
+}
+
+// readInstalled reads the manifests in dir matching pattern, newest version
+// first.
+func readInstalled(dir, pattern string) ([]installedManifest, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return nil, err
+	}
+	var found []installedManifest
+	for _, match := range matches {
+		data, err := os.ReadFile(match)
+		if err != nil {
+			return nil, err
+		}
+		var manifest packager.Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: %w", match, err)
+		}
+		version, _ := ParseVersion(manifest.Version)
+		found = append(found, installedManifest{path: match, manifest: &manifest, version: version})
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].version.GreaterThan(found[j].version) })
+	return found, nil
+}
+
+func removeFile(root, rel string) (bool, error) {
+	if err := checkParents(root, rel); err != nil {
+		return false, err
+	}
+	target := filepath.Join(root, filepath.FromSlash(rel))
+	info, err := os.Lstat(target)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	if info.IsDir() {
+		return false, fmt.Errorf("%s is a directory, expected file", target)
+	}
+	if err := os.Remove(target); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func parentDirs(rel string) []string {
+	var dirs []string
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// removeEmptyDirs removes the given directories, deepest first, as long as
+// they are empty. Directories still used by other packages stay.
+func removeEmptyDirs(root string, dirs []string) {
+	sort.Slice(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i], "/") > strings.Count(dirs[j], "/")
+	})
+	for _, dir := range dirs {
+		os.Remove(filepath.Join(root, filepath.FromSlash(dir)))
+	}
+}
@@ -0,0 +1,68 @@
+package updater
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"pm/internal/packager"
+)
+
+// scriptPath returns the location of a hook's script below root, or "" if
+// the package has no such hook. The manifest is trusted only as far as
+// naming a file in the package's own script directory.
+func scriptPath(manifest *packager.Manifest, root, hook string) (string, error) {
+	name, ok := manifest.Scripts[hook]
+	if !ok {
+		return "", nil
+	}
+	if path.Clean(name) != path.Join(packager.MetaDir(manifest.Name), hook) {
+		return "", fmt.Errorf("package %s declares %s script at unexpected path %s", manifest.Name, hook, name)
+	}
+	return filepath.Join(root, filepath.FromSlash(name)), nil
+}
+
+// runScript runs a lifecycle script with the install directory as working
+// directory. The script's output is only shown when it fails.
+func runScript(manifest *packager.Manifest, script, hook, installDir string) error {
+	absDir, err := filepath.Abs(installDir)
+	if err != nil {
+		return err
+	}
+	absScript, err := filepath.Abs(script)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(absScript)
+	cmd.Dir = absDir
+	cmd.Env = append(os.Environ(),
+		"PM_PACKAGE="+manifest.Name,
+		"PM_VERSION="+manifest.Version,
+		"PM_INSTALL_DIR="+absDir,
+		"PM_HOOK="+hook,
+	)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		output := strings.TrimSpace(out.String())
+		if output == "" {
+			return fmt.Errorf("%s script of %s %s failed: %w", hook, manifest.Name, manifest.Version, err)
+		}
+		return fmt.Errorf("%s script of %s %s failed: %w\n%s", hook, manifest.Name, manifest.Version, err, output)
+	}
+	return nil
+}
+
+// runHook runs the hook's script from root, if the package has one.
+func runHook(manifest *packager.Manifest, root, hook, installDir string) error {
+	script, err := scriptPath(manifest, root, hook)
+	if err != nil || script == "" {
+		return err
+	}
+	return runScript(manifest, script, hook, installDir)
+}
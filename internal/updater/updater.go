@@ -1,12 +1,8 @@
 package updater
 
 import (
-	"archive/tar"
-	"compress/gzip"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path"
 	"path/filepath"
@@ -14,26 +10,45 @@ import (
 	"strconv"
 	"strings"
 
+	"pm/internal/archive"
 	"pm/internal/config"
 	"pm/internal/packager"
+	"pm/internal/signing"
 	"pm/internal/sshcmd"
 )
 
 type UpdateOptions struct {
-	RemoteDir string
-	LocalDir  string
-	SSH       sshcmd.Config
+	RemoteDir     string
+	LocalDir      string
+	SSH           sshcmd.Config
+	TrustedKeys   []signing.PublicKey
+	AllowUnsigned bool
+	Limits        ExtractLimits
+	NoScripts     bool
+	// WithOptional installs optional dependencies that no package asked
+	// for with "with".
+	WithOptional bool
+	// Platform selects among os/arch variants of a package; packages built
+	// for "any" fit every platform. It defaults to the host's.
+	Platform config.Platform
 }
 
 type Result struct {
 	PackageName string
 	Version     string
+	Platform    config.Platform
 	ArchivePath string
 	ExtractedTo string
 	Manifest    string
+	SignedBy    string
+	// Replaced lists the packages removed because this one replaces them.
+	Replaced []string
 }
 
 func Update(spec *config.UpdateSpec, opts UpdateOptions) ([]Result, error) {
+	if opts.Platform.IsAny() {
+		opts.Platform = config.HostPlatform()
+	}
 	entries, err := listRemoteArchives(opts.SSH, opts.RemoteDir)
 	if err != nil {
 		return nil, err
@@ -48,10 +63,14 @@ func Update(spec *config.UpdateSpec, opts UpdateOptions) ([]Result, error) {
 		sortPackages(available[k])
 	}
 
+	state, err := newInstallState(opts.LocalDir)
+	if err != nil {
+		return nil, err
+	}
+
 	var results []Result
-	installed := map[string]Version{}
 	for _, dep := range spec.Packages {
-		if err := installPackage(dep, available, opts, installed, &results); err != nil {
+		if err := installPackage(dep, available, opts, state, &results); err != nil {
 			return nil, err
 		}
 	}
@@ -59,9 +78,11 @@ func Update(spec *config.UpdateSpec, opts UpdateOptions) ([]Result, error) {
 }
 
 type remotePackage struct {
-	Name    string
-	Version Version
-	Path    string
+	Name          string
+	Version       Version
+	Platform      config.Platform
+	Path          string
+	SignaturePath string
 }
 
 func listRemoteArchives(cfg sshcmd.Config, dir string) ([]remotePackage, error) {
@@ -72,66 +93,107 @@ func listRemoteArchives(cfg sshcmd.Config, dir string) ([]remotePackage, error)
 	if err != nil {
 		return nil, err
 	}
-	var pkgs []remotePackage
+	var lines []string
+	present := map[string]struct{}{}
 	for _, line := range strings.Split(out, "\n") {
 		line = strings.TrimSpace(line)
 		if line == "" {
 			continue
 		}
-		name, version, ok := parseArchiveName(line)
+		lines = append(lines, line)
+		present[line] = struct{}{}
+	}
+
+	var pkgs []remotePackage
+	for _, line := range lines {
+		name, version, platform, ok := parseArchiveName(line)
 		if !ok {
 			continue
 		}
-		pkgs = append(pkgs, remotePackage{
-			Name:    name,
-			Version: version,
-			Path:    path.Join(dir, line),
-		})
+		pkg := remotePackage{
+			Name:     name,
+			Version:  version,
+			Platform: platform,
+			Path:     path.Join(dir, line),
+		}
+		if _, ok := present[line+signing.SignatureExt]; ok {
+			pkg.SignaturePath = pkg.Path + signing.SignatureExt
+		}
+		pkgs = append(pkgs, pkg)
 	}
 	return pkgs, nil
 }
 
-func parseArchiveName(filename string) (string, Version, bool) {
-	if !strings.HasSuffix(filename, ".tar.gz") {
-		return "", Version{}, false
+// parseArchiveName splits "name-version[-os-arch].ext". Archives without a
+// platform suffix are platform-independent.
+func parseArchiveName(filename string) (string, Version, config.Platform, bool) {
+	_, trimmed, ok := archive.ForFile(filename)
+	if !ok {
+		return "", Version{}, config.Platform{}, false
 	}
-	trimmed := strings.TrimSuffix(filename, ".tar.gz")
+	trimmed, platform := config.ParsePlatformSuffix(trimmed)
 	parts := strings.Split(trimmed, "-")
 	if len(parts) < 2 {
-		return "", Version{}, false
+		return "", Version{}, config.Platform{}, false
 	}
 	versionStr := parts[len(parts)-1]
 	name := strings.Join(parts[:len(parts)-1], "-")
 	version, err := ParseVersion(versionStr)
 	if err != nil {
-		return "", Version{}, false
+		return "", Version{}, config.Platform{}, false
 	}
-	return name, version, true
+	return name, version, platform, true
 }
 
+// sortPackages orders packages newest first; builds of the same version go
+// from the most to the least platform-specific.
 func sortPackages(pkgs []remotePackage) {
 	sort.Slice(pkgs, func(i, j int) bool {
-		return pkgs[i].Version.GreaterThan(pkgs[j].Version)
+		if c := pkgs[i].Version.Compare(pkgs[j].Version); c != 0 {
+			return c > 0
+		}
+		return pkgs[i].Platform.Specificity() > pkgs[j].Platform.Specificity()
 	})
 }
 
-func selectVersion(pkgs []remotePackage, constraint string) (*remotePackage, error) {
-	if constraint == "" {
-		return &pkgs[0], nil
-	}
-	c, err := ParseConstraint(constraint)
-	if err != nil {
-		return nil, err
+// selectVersion picks the newest package that satisfies the constraint and
+// can be installed on host, preferring a build for host over an "any" one.
+func selectVersion(pkgs []remotePackage, constraint string, host config.Platform) (*remotePackage, error) {
+	var c Constraint
+	if constraint != "" {
+		var err error
+		if c, err = ParseConstraint(constraint); err != nil {
+			return nil, err
+		}
 	}
+	var platforms []string
+	seen := map[config.Platform]bool{}
 	for _, pkg := range pkgs {
-		if c.Matches(pkg.Version) {
+		if constraint != "" && !c.Matches(pkg.Version) {
+			continue
+		}
+		if pkg.Platform.Matches(host) {
 			p := pkg
 			return &p, nil
 		}
+		if !seen[pkg.Platform] {
+			seen[pkg.Platform] = true
+			platforms = append(platforms, pkg.Platform.String())
+		}
+	}
+	if len(platforms) > 0 {
+		return nil, fmt.Errorf("no versions of %s%s are built for %s (available for %s)", pkgs[0].Name, constraintSuffix(constraint), host, strings.Join(platforms, ", "))
 	}
 	return nil, fmt.Errorf("no versions of %s satisfy constraint %s", pkgs[0].Name, constraint)
 }
 
+func constraintSuffix(constraint string) string {
+	if constraint == "" {
+		return ""
+	}
+	return " " + constraint
+}
+
 type Version struct {
 	parts    []int
 	original string
@@ -243,56 +305,6 @@ func (c Constraint) Matches(v Version) bool {
 	}
 }
 
-func extractArchive(path, dest string) error {
-	file, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	gz, err := gzip.NewReader(file)
-	if err != nil {
-		return err
-	}
-	defer gz.Close()
-
-	tr := tar.NewReader(gz)
-
-	for {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-
-		targetPath := filepath.Join(dest, header.Name)
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(targetPath, os.FileMode(header.Mode)); err != nil {
-				return err
-			}
-		case tar.TypeReg:
-			if err := os.MkdirAll(filepath.Dir(targetPath), 0o755); err != nil {
-				return err
-			}
-			file, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
-			if err != nil {
-				return err
-			}
-			if _, err := io.Copy(file, tr); err != nil {
-				file.Close()
-				return err
-			}
-			file.Close()
-		default:
-			// ignore other types
-		}
-	}
-	return nil
-}
-
 func ensureManifestUnique(dir, pkgName, version string) (string, error) {
 	src := filepath.Join(dir, "manifest.json")
 	info, err := os.Stat(src)
@@ -313,29 +325,30 @@ func ensureManifestUnique(dir, pkgName, version string) (string, error) {
 }
 
 func manifestFilename(pkgName, version string) string {
-	sanitize := func(input string) string {
-		var b strings.Builder
-		for _, r := range input {
-			switch {
-			case r >= 'a' && r <= 'z':
-				b.WriteRune(r)
-			case r >= 'A' && r <= 'Z':
-				b.WriteRune(r)
-			case r >= '0' && r <= '9':
-				b.WriteRune(r)
-			case r == '-', r == '_', r == '.':
-				b.WriteRune(r)
-			default:
-				b.WriteRune('_')
-			}
+	return fmt.Sprintf("manifest-%s-%s.json", sanitizeName(pkgName), sanitizeName(version))
+}
+
+func sanitizeName(input string) string {
+	var b strings.Builder
+	for _, r := range input {
+		switch {
+		case r >= 'a' && r <= 'z':
+			b.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '-', r == '_', r == '.':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
 		}
-		return b.String()
 	}
-	return fmt.Sprintf("manifest-%s-%s.json", sanitize(pkgName), sanitize(version))
+	return b.String()
 }
 
-func installPackage(dep config.DependencySpec, available map[string][]remotePackage, opts UpdateOptions, installed map[string]Version, results *[]Result) error {
-	if installedVersion, ok := installed[dep.Name]; ok {
+func installPackage(dep config.DependencySpec, available map[string][]remotePackage, opts UpdateOptions, state *installState, results *[]Result) error {
+	if installedVersion, ok := state.versions[dep.Name]; ok {
 		if dep.Version == "" {
 			return nil
 		}
@@ -349,12 +362,20 @@ func installPackage(dep config.DependencySpec, available map[string][]remotePack
 		return fmt.Errorf("package %s already installed with version %s which does not satisfy constraint %s", dep.Name, installedVersion.String(), dep.Version)
 	}
 
+	provider, err := state.provider(dep)
+	if err != nil {
+		return err
+	}
+	if provider != nil {
+		return nil
+	}
+
 	candidates := available[dep.Name]
 	if len(candidates) == 0 {
-		return fmt.Errorf("package %s not found on remote", dep.Name)
+		return fmt.Errorf("package %s not found on remote and no installed package provides it", dep.Name)
 	}
 
-	selected, err := selectVersion(candidates, dep.Version)
+	selected, err := selectVersion(candidates, dep.Version, opts.Platform)
 	if err != nil {
 		return err
 	}
@@ -364,11 +385,61 @@ func installPackage(dep config.DependencySpec, available map[string][]remotePack
 		return err
 	}
 
+	signer, err := verifySignature(localArchive, selected, opts)
+	if err != nil {
+		return err
+	}
+
 	extractDir := opts.LocalDir
 	if extractDir == "" {
 		extractDir = "."
 	}
-	if err := extractArchive(localArchive, extractDir); err != nil {
+	stage, err := stageArchive(localArchive, extractDir, opts.Limits)
+	if err != nil {
+		return err
+	}
+	defer stage.cleanup()
+	if err := checkScripts(stage); err != nil {
+		return err
+	}
+	if err := checkIdentity(stage.manifest, selected); err != nil {
+		return err
+	}
+	// The archive name is only a hint; the manifest says what was built.
+	built := config.Platform{OS: stage.manifest.OS, Arch: stage.manifest.Arch}
+	if !built.Matches(opts.Platform) {
+		return fmt.Errorf("package %s %s is built for %s, not %s", dep.Name, selected.Version, built, opts.Platform)
+	}
+
+	if err := state.checkConflicts(stage.manifest); err != nil {
+		return err
+	}
+
+	state.versions[dep.Name] = selected.Version
+	state.present[dep.Name] = stage.manifest
+
+	// Dependencies go in first so that this package's scripts can rely on
+	// them.
+	for _, child := range stage.manifest.Dependencies {
+		if !wanted(child, dep, opts) {
+			continue
+		}
+		if err := installPackage(child, available, opts, state, results); err != nil {
+			return err
+		}
+	}
+
+	replaced, err := state.removeReplaced(stage.manifest, opts)
+	if err != nil {
+		return err
+	}
+
+	if !opts.NoScripts {
+		if err := runHook(stage.manifest, stage.dir, config.HookPreInstall, extractDir); err != nil {
+			return err
+		}
+	}
+	if err := stage.commit(); err != nil {
 		return err
 	}
 
@@ -377,40 +448,72 @@ func installPackage(dep config.DependencySpec, available map[string][]remotePack
 		return err
 	}
 
-	installed[dep.Name] = selected.Version
+	if !opts.NoScripts {
+		if err := runHook(stage.manifest, extractDir, config.HookPostInstall, extractDir); err != nil {
+			return err
+		}
+	}
 
-	res := Result{
+	*results = append(*results, Result{
 		PackageName: dep.Name,
 		Version:     selected.Version.String(),
+		Platform:    selected.Platform,
 		ArchivePath: localArchive,
 		ExtractedTo: extractDir,
 		Manifest:    manifestPath,
-	}
-	*results = append(*results, res)
+		SignedBy:    signer,
+		Replaced:    replaced,
+	})
+	return nil
+}
 
-	deps, err := loadManifestDependencies(manifestPath)
-	if err != nil {
-		return err
-	}
-	for _, child := range deps {
-		if err := installPackage(child, available, opts, installed, results); err != nil {
+// checkScripts makes sure every hook in the manifest is a regular file
+// shipped in the package's script directory.
+func checkScripts(stage *stagedArchive) error {
+	for hook := range stage.manifest.Scripts {
+		script, err := scriptPath(stage.manifest, "", hook)
+		if err != nil {
 			return err
 		}
+		if stage.files[filepath.ToSlash(script)].kind != packager.EntryFile {
+			return fmt.Errorf("package %s has no %s script file %s", stage.manifest.Name, hook, script)
+		}
+	}
+	return nil
+}
+
+// checkIdentity refuses an archive whose manifest names another package or
+// version than the file it was selected by, such as an old release copied
+// over a newer name.
+func checkIdentity(manifest *packager.Manifest, pkg *remotePackage) error {
+	version, err := ParseVersion(manifest.Version)
+	if manifest.Name != pkg.Name || err != nil || version.Compare(pkg.Version) != 0 {
+		return fmt.Errorf("archive %s holds package %s %s, not %s %s", path.Base(pkg.Path), manifest.Name, manifest.Version, pkg.Name, pkg.Version.String())
 	}
 	return nil
 }
 
-func loadManifestDependencies(manifestPath string) ([]config.DependencySpec, error) {
-	if manifestPath == "" {
-		return nil, nil
+func verifySignature(localArchive string, pkg *remotePackage, opts UpdateOptions) (string, error) {
+	if pkg.SignaturePath == "" {
+		if opts.AllowUnsigned {
+			return "", nil
+		}
+		return "", fmt.Errorf("package %s %s is not signed", pkg.Name, pkg.Version.String())
+	}
+	if len(opts.TrustedKeys) == 0 {
+		if opts.AllowUnsigned {
+			return "", nil
+		}
+		return "", fmt.Errorf("no trusted keys configured to verify package %s %s", pkg.Name, pkg.Version.String())
 	}
-	data, err := os.ReadFile(manifestPath)
+
+	localSig, err := sshcmd.DownloadFile(opts.SSH, pkg.SignaturePath, filepath.Dir(localArchive))
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	var manifest packager.Manifest
-	if err := json.Unmarshal(data, &manifest); err != nil {
-		return nil, err
+	key, err := signing.VerifyFile(localArchive, localSig, opts.TrustedKeys)
+	if err != nil {
+		return "", err
 	}
-	return manifest.Dependencies, nil
+	return key.Name, nil
 }
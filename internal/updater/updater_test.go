@@ -0,0 +1,178 @@
+package updater
+
+import (
+	"strings"
+	"testing"
+
+	"pm/internal/config"
+	"pm/internal/packager"
+)
+
+func TestCheckIdentity(t *testing.T) {
+	selected := &remotePackage{Name: "app", Version: mustVersion(t, "9.9"), Path: "repo/app-9.9.tar.gz"}
+	tests := []struct {
+		name     string
+		manifest packager.Manifest
+		err      string
+	}{
+		{name: "same", manifest: packager.Manifest{Name: "app", Version: "9.9"}},
+		{name: "same version written longer", manifest: packager.Manifest{Name: "app", Version: "9.9.0"}},
+		{name: "older release", manifest: packager.Manifest{Name: "app", Version: "1.0"}, err: "archive app-9.9.tar.gz holds package app 1.0, not app 9.9"},
+		{name: "other package", manifest: packager.Manifest{Name: "tool", Version: "9.9"}, err: "holds package tool 9.9, not app 9.9"},
+		{name: "unparsable version", manifest: packager.Manifest{Name: "app", Version: "latest"}, err: "holds package app latest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkIdentity(&tt.manifest, selected)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseArchiveName(t *testing.T) {
+	tests := []struct {
+		filename string
+		name     string
+		version  string
+		platform config.Platform
+		ok       bool
+	}{
+		{filename: "app-1.0.tar.gz", name: "app", version: "1.0", ok: true},
+		{filename: "my-app-2.10.1.zip", name: "my-app", version: "2.10.1", ok: true},
+		{filename: "app-1.0-linux-amd64.tar.zst", name: "app", version: "1.0", platform: config.Platform{OS: "linux", Arch: "amd64"}, ok: true},
+		{filename: "my-app-1.0-darwin-any.tar", name: "my-app", version: "1.0", platform: config.Platform{OS: "darwin"}, ok: true},
+		{filename: "app-1.0-any-arm64.zip", name: "app", version: "1.0", platform: config.Platform{Arch: "arm64"}, ok: true},
+		{filename: "app-1.0.tar.gz.sig"},
+		{filename: "app.tar.gz"},
+		{filename: "app-latest.tar.gz"},
+		{filename: "app-1.0-linux-amd64.rpm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			name, version, platform, ok := parseArchiveName(tt.filename)
+			if ok != tt.ok {
+				t.Fatalf("got ok %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if name != tt.name || version.String() != tt.version || platform != tt.platform {
+				t.Errorf("got %s %s %+v, want %s %s %+v", name, version, platform, tt.name, tt.version, tt.platform)
+			}
+		})
+	}
+}
+
+func TestSelectVersion(t *testing.T) {
+	pkg := func(version, suffix string) remotePackage {
+		filename := "app-" + version + suffix + ".tar.gz"
+		name, v, platform, ok := parseArchiveName(filename)
+		if !ok {
+			t.Fatalf("cannot parse %s", filename)
+		}
+		return remotePackage{Name: name, Version: v, Platform: platform, Path: filename}
+	}
+	linux := config.Platform{OS: "linux", Arch: "amd64"}
+	tests := []struct {
+		name       string
+		pkgs       []remotePackage
+		constraint string
+		host       config.Platform
+		want       string
+		err        string
+	}{
+		{
+			name: "matching variant",
+			pkgs: []remotePackage{pkg("1.0", "-darwin-arm64"), pkg("1.0", "-linux-amd64"), pkg("1.0", "-linux-arm64")},
+			host: linux,
+			want: "app-1.0-linux-amd64.tar.gz",
+		},
+		{
+			name: "variant preferred over any",
+			pkgs: []remotePackage{pkg("1.0", ""), pkg("1.0", "-linux-any"), pkg("1.0", "-linux-amd64")},
+			host: linux,
+			want: "app-1.0-linux-amd64.tar.gz",
+		},
+		{
+			name: "os-only variant preferred over any",
+			pkgs: []remotePackage{pkg("1.0", ""), pkg("1.0", "-linux-any")},
+			host: linux,
+			want: "app-1.0-linux-any.tar.gz",
+		},
+		{
+			name: "any-platform fallback",
+			pkgs: []remotePackage{pkg("1.0", "-darwin-arm64"), pkg("1.0", "")},
+			host: linux,
+			want: "app-1.0.tar.gz",
+		},
+		{
+			name: "newer any beats older variant",
+			pkgs: []remotePackage{pkg("1.0", "-linux-amd64"), pkg("2.0", "")},
+			host: linux,
+			want: "app-2.0.tar.gz",
+		},
+		{
+			name:       "constraint",
+			pkgs:       []remotePackage{pkg("1.0", "-linux-amd64"), pkg("2.0", "-linux-amd64")},
+			constraint: "<2",
+			host:       linux,
+			want:       "app-1.0-linux-amd64.tar.gz",
+		},
+		{
+			name: "no build for host",
+			pkgs: []remotePackage{pkg("1.0", "-darwin-arm64"), pkg("1.0", "-windows-any")},
+			host: linux,
+			err:  "no versions of app are built for linux/amd64 (available for darwin/arm64, windows/any)",
+		},
+		{
+			name:       "no version satisfies",
+			pkgs:       []remotePackage{pkg("1.0", "")},
+			constraint: ">=2",
+			host:       linux,
+			err:        "no versions of app satisfy constraint >=2",
+		},
+		{
+			name:       "bad constraint",
+			pkgs:       []remotePackage{pkg("1.0", "")},
+			constraint: "<<2",
+			host:       linux,
+			err:        "invalid",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkgs := append([]remotePackage(nil), tt.pkgs...)
+			sortPackages(pkgs)
+			got, err := selectVersion(pkgs, tt.constraint, tt.host)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want error %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Path != tt.want {
+				t.Errorf("got %s, want %s", got.Path, tt.want)
+			}
+		})
+	}
+}
+
+func mustVersion(t *testing.T, s string) Version {
+	t.Helper()
+	v, err := ParseVersion(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
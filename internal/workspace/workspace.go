@@ -0,0 +1,190 @@
+package workspace
+
+import (
+	"fmt"
+	"strings"
+
+	"pm/internal/config"
+)
+
+type Status int
+
+const (
+	StatusOK Status = iota
+	StatusFailed
+	StatusSkipped
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOK:
+		return "ok"
+	case StatusFailed:
+		return "failed"
+	default:
+		return "skipped"
+	}
+}
+
+type Result struct {
+	Member  *config.WorkspaceMember
+	Status  Status
+	Archive string
+	Files   int
+	Err     error
+}
+
+// BuildFunc builds one member and returns the archive path and file count.
+type BuildFunc func(member *config.WorkspaceMember) (string, int, error)
+
+// Build runs build for every member, at most jobs at a time. A member starts
+// only after the members named in its packets have been built; if one of
+// those fails, the member is skipped. Results are in workspace order.
+func Build(ws *config.Workspace, jobs int, build BuildFunc) ([]Result, error) {
+	deps, err := dependencies(ws)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCycles(ws, deps); err != nil {
+		return nil, err
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	n := len(ws.Members)
+	results := make([]Result, n)
+	dependents := make([][]int, n)
+	waiting := make([]int, n)
+	for i := range ws.Members {
+		results[i].Member = &ws.Members[i]
+		waiting[i] = len(deps[i])
+		for _, d := range deps[i] {
+			dependents[d] = append(dependents[d], i)
+		}
+	}
+
+	var ready []int
+	for i := range ws.Members {
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	done := make(chan int)
+	running, finished := 0, 0
+
+	var settle func(i int)
+	settle = func(i int) {
+		finished++
+		for _, d := range dependents[i] {
+			if results[i].Status != StatusOK {
+				if results[d].Err != nil {
+					continue
+				}
+				results[d].Status = StatusSkipped
+				results[d].Err = fmt.Errorf("dependency %s was not built", results[i].Member.Spec.Name)
+				settle(d)
+				continue
+			}
+			waiting[d]--
+			if waiting[d] == 0 && results[d].Err == nil {
+				ready = append(ready, d)
+			}
+		}
+	}
+
+	for finished < n {
+		for len(ready) > 0 && running < jobs {
+			i := ready[0]
+			ready = ready[1:]
+			running++
+			go func(i int) {
+				archive, files, err := build(&ws.Members[i])
+				results[i].Archive, results[i].Files, results[i].Err = archive, files, err
+				if err != nil {
+					results[i].Status = StatusFailed
+				}
+				done <- i
+			}(i)
+		}
+		if running == 0 {
+			break
+		}
+		i := <-done
+		running--
+		settle(i)
+	}
+	return results, nil
+}
+
+// dependencies maps every member to the members named in its packets.
+// Dependencies on packages outside the workspace are resolved at update time
+// and do not affect the build order.
+func dependencies(ws *config.Workspace) ([][]int, error) {
+	index := map[string]int{}
+	for i, m := range ws.Members {
+		index[m.Spec.Name] = i
+	}
+	deps := make([][]int, len(ws.Members))
+	for i, m := range ws.Members {
+		for _, dep := range m.Spec.Packages {
+			j, ok := index[dep.Name]
+			if !ok {
+				continue
+			}
+			if j == i {
+				return nil, fmt.Errorf("package %s depends on itself", m.Spec.Name)
+			}
+			deps[i] = append(deps[i], j)
+		}
+	}
+	return deps, nil
+}
+
+func checkCycles(ws *config.Workspace, deps [][]int) error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(deps))
+	var stack []int
+	var cycle []int
+	var visit func(i int) bool
+	visit = func(i int) bool {
+		switch state[i] {
+		case visiting:
+			for k := len(stack) - 1; k >= 0; k-- {
+				if stack[k] == i {
+					cycle = stack[k:]
+					break
+				}
+			}
+			return true
+		case visited:
+			return false
+		}
+		state[i] = visiting
+		stack = append(stack, i)
+		for _, d := range deps[i] {
+			if visit(d) {
+				return true
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[i] = visited
+		return false
+	}
+	for i := range deps {
+		if state[i] == unvisited && visit(i) {
+			names := make([]string, 0, len(cycle)+1)
+			for _, c := range cycle {
+				names = append(names, ws.Members[c].Spec.Name)
+			}
+			names = append(names, names[0])
+			return fmt.Errorf("dependency cycle between workspace packages: %s", strings.Join(names, " -> "))
+		}
+	}
+	return nil
+}